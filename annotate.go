@@ -0,0 +1,73 @@
+package godefinfo
+
+import (
+	"go/ast"
+)
+
+// An Annotation describes an identifier in a file and the definition
+// it refers to.
+type Annotation struct {
+	// Start and End delimit the identifier as 1-based byte offsets
+	// (End is exclusive), like Query.Offset.
+	Start, End int
+
+	// IsDef is whether the identifier declares the definition (as
+	// opposed to referring to it).
+	IsDef bool
+
+	*DefInfo
+}
+
+// Annotate resolves every identifier in the named file, which must be
+// one of the package's files (or, if empty, the file that Check was
+// called with), and returns the annotations in file order. Identifiers
+// whose definitions can't be resolved (eg, the blank identifier) are
+// omitted, as are methods of anonymous interfaces.
+func (p *Package) Annotate(filename string) ([]*Annotation, error) {
+	file := p.file(filename)
+	if file == nil {
+		return nil, errorf(ErrNoIdentifier, "%s is not in package %s", filename, p.pkg.Path())
+	}
+	tf := p.r.fset.File(file.Pos())
+	if tf == nil {
+		return nil, errorf(ErrNoIdentifier, "%s has no package clause", filename)
+	}
+	base, name := tf.Base(), absPath(tf.Name())
+
+	var anns []*Annotation
+	ast.Inspect(file, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || id.Name == "_" || id == file.Name {
+			return true
+		}
+		start := int(id.Pos()) - base + 1
+		def, err := p.resolveEach(file, start)
+		if err != nil {
+			p.r.dlog.Printf("%s: %s", p.r.fset.Position(id.Pos()), err)
+			return true
+		}
+		// The symbolic variable of a type switch has no entry in
+		// Defs, but it is where its clauses' implicit variables are.
+		isDef := p.info.Defs[id] != nil || (def.Filename == name && def.Offset == start)
+		anns = append(anns, &Annotation{
+			Start:   start,
+			End:     start + len(id.Name),
+			IsDef:   isDef,
+			DefInfo: def,
+		})
+		return true
+	})
+	return anns, nil
+}
+
+// resolveEach is resolveFile for callers that resolve every identifier
+// in a file: a panic while resolving the identifier at offset is
+// returned as an error, so that only that identifier is lost.
+func (p *Package) resolveEach(file *ast.File, offset int) (def *DefInfo, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			def, err = nil, errorf(ErrUnsupported, "resolving identifier: %v", v)
+		}
+	}()
+	return p.resolveFile(file, offset)
+}
@@ -0,0 +1,66 @@
+package godefinfo
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	const src = `package p
+
+import "fmt"
+
+type T struct{ F int }
+
+func (t T) M() string { return fmt.Sprint(t.F) }
+
+const C = 1
+
+var _ = T{F: C}.M
+
+var X interface{ M() }
+`
+	r := NewResolver(Options{Strict: true, ImportSrc: true})
+	p, err := r.Check(context.Background(), Query{Filename: filepath.Join(t.TempDir(), "p.go"), Src: []byte(src)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anns, err := p.Annotate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, ann := range anns {
+		role := "ref"
+		if ann.IsDef {
+			role = "def"
+		}
+		got = append(got, fmt.Sprintf("%s %s %s %s", src[ann.Start-1:ann.End-1], role, ann.Kind, ann.DefInfo))
+	}
+	want := []string{
+		"T def type p T",
+		"F def field p T F",
+		"int ref builtin builtin int",
+		"t def local p T.M t",
+		"T ref type p T",
+		"M def method p T M",
+		"string ref builtin builtin string",
+		"fmt ref package fmt",
+		"Sprint ref func fmt Sprint",
+		"t ref local p T.M t",
+		"F ref field p T F",
+		"C def const p C",
+		"T ref type p T",
+		"F ref field p T F",
+		"C ref const p C",
+		"M ref method p T M",
+		"X def var p X", // but not the anonymous interface's M
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got annotations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
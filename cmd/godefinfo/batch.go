@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sqs/godefinfo"
+)
+
+// batchResult is the answer to one -batch query. Exactly one of Def
+// and Error is set.
+type batchResult struct {
+	Filename string             `json:",omitempty"`
+	Offset   int                `json:",omitempty"`
+	Def      *godefinfo.DefInfo `json:",omitempty"`
+	Error    *jsonError         `json:",omitempty"`
+}
+
+// runBatch reads queries from in, one per line, and writes one JSON
+// result line per query to out, in order. Each query is either a byte
+// offset in the package's primary file or "file.go:offset" for
+// another file in the same package. Queries are answered by resolve
+// (a Package's Resolve method, so the package is only type-checked
+// once); a failed query, even one that panics, is reported in its
+// result and does not stop the others.
+func runBatch(resolve func(filename string, offset int) (*godefinfo.DefInfo, error), in io.Reader, out io.Writer) error {
+	enc := json.NewEncoder(out)
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		if err := enc.Encode(batchQuery(resolve, line)); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// batchQuery answers one -batch query line with resolve.
+func batchQuery(resolve func(filename string, offset int) (*godefinfo.DefInfo, error), line string) (res batchResult) {
+	defer func() {
+		// A panic fails only this query, not the batch.
+		if v := recover(); v != nil {
+			res = batchResult{Filename: res.Filename, Offset: res.Offset, Error: recoverError(v)}
+		}
+	}()
+	filename, offset, err := parseBatchQuery(line)
+	if err == nil {
+		res.Filename, res.Offset = filename, offset
+		res.Def, err = resolve(filename, offset)
+	}
+	if err != nil {
+		res.Error = newJSONError(err)
+	}
+	return res
+}
+
+// parseBatchQuery parses a -batch query of the form "offset" or
+// "file.go:offset".
+func parseBatchQuery(line string) (filename string, offset int, err error) {
+	offsetStr := line
+	if i := strings.LastIndex(line, ":"); i >= 0 {
+		filename, offsetStr = line[:i], line[i+1:]
+	}
+	offset, err = strconv.Atoi(offsetStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("bad query %q (want offset or file.go:offset)", line)
+	}
+	return filename, offset, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sqs/godefinfo"
+)
+
+func TestBatch(t *testing.T) {
+	t.Setenv("GO111MODULE", "off")
+	dir := t.TempDir()
+	const (
+		a = "package p\n\nfunc F() { G() }\n"
+		b = "package p\n\nfunc G() { F() }\n"
+	)
+	aFile, bFile := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+	if err := ioutil.WriteFile(aFile, []byte(a), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(bFile, []byte(b), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := godefinfo.NewResolver(godefinfo.Options{ImportSrc: true})
+	p, err := r.Check(context.Background(), godefinfo.Query{Filename: aFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := []struct {
+		query   string
+		want    string
+		wantErr bool
+	}{
+		{fmt.Sprint(strings.Index(a, "G()") + 1), "p G", false},
+		{fmt.Sprintf("%s:%d", bFile, strings.Index(b, "F()")+1), "p F", false},
+		{"1", "", true},               // not an identifier
+		{"1000", "", true},            // past the end of the file
+		{"/tmp/other.go:1", "", true}, // not in the package
+		{"x", "", true},               // malformed
+		{fmt.Sprint(strings.Index(a, "F()") + 1), "p F", false},
+	}
+	var in bytes.Buffer
+	for _, q := range queries {
+		fmt.Fprintln(&in, q.query)
+	}
+
+	var out bytes.Buffer
+	if err := runBatch(p.Resolve, &in, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(&out)
+	for i, q := range queries {
+		var res batchResult
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("result %d: %s", i, err)
+		}
+		switch {
+		case q.wantErr && res.Error == nil:
+			t.Errorf("%s: got def %v, want error", q.query, res.Def)
+		case !q.wantErr && res.Def == nil:
+			t.Errorf("%s: got error %+v, want %q", q.query, res.Error, q.want)
+		case !q.wantErr && res.Def.String() != q.want:
+			t.Errorf("%s: got %q, want %q", q.query, res.Def, q.want)
+		}
+	}
+	if dec.More() {
+		t.Error("got more results than queries")
+	}
+}
+
+func TestBatchPanic(t *testing.T) {
+	resolve := func(filename string, offset int) (*godefinfo.DefInfo, error) {
+		if offset == 2 {
+			panic("boom")
+		}
+		return &godefinfo.DefInfo{Package: "p", Name: fmt.Sprint("F", offset)}, nil
+	}
+	var out bytes.Buffer
+	if err := runBatch(resolve, strings.NewReader("1\n2\n3\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(&out)
+	for _, want := range []string{"p F1", "", "p F3"} {
+		var res batchResult
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case want == "" && (res.Error == nil || res.Error.Code != errInternal || res.Offset != 2):
+			t.Errorf("got %+v, want an internal error for offset 2", res)
+		case want != "" && (res.Def == nil || res.Def.String() != want):
+			t.Errorf("got %+v, want %q", res, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+
+	"github.com/sqs/godefinfo"
+)
+
+// writeIndex indexes the packages in root and writes the index to
+// filename.
+func writeIndex(r *godefinfo.Resolver, root, filename string) error {
+	ix, err := r.BuildIndex(context.Background(), root)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := ix.Write(w); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readIndex reads the index in filename.
+func readIndex(filename string) (*godefinfo.Index, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return godefinfo.ReadIndex(bufio.NewReader(f))
+}
+
+// runQuery answers a query subcommand from the index: with -mode=def,
+// the definition of the identifier at q's offset (or with descriptor
+// desc, if it is non-empty), and with -mode=refs, the references to
+// it.
+func runQuery(ix *godefinfo.Index, q godefinfo.Query, desc string, enc godefinfo.ColumnEncoding) error {
+	if *mode == "refs" {
+		var refs []*godefinfo.Reference
+		var err error
+		if desc != "" {
+			refs, err = ix.LookupReferences(desc)
+		} else {
+			refs, err = ix.References(q.Filename, q.Offset)
+		}
+		if err != nil {
+			return err
+		}
+		outputRefs(q, refs, enc)
+		return nil
+	}
+
+	var defs []*godefinfo.DefInfo
+	if desc != "" {
+		var err error
+		if defs, err = ix.Lookup(desc); err != nil {
+			return err
+		}
+	} else {
+		def, err := ix.Definition(q.Filename, q.Offset)
+		if err != nil {
+			return err
+		}
+		defs = []*godefinfo.DefInfo{def}
+	}
+	for _, def := range defs {
+		convertColumns(q, def, enc)
+	}
+	if desc == "" {
+		outputData(defs[0])
+	} else {
+		outputDefs(defs, true)
+	}
+	return nil
+}
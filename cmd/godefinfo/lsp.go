@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+
+	"github.com/sqs/godefinfo"
+)
+
+// serveLSP runs a minimal Language Server Protocol server on in and
+// out. It answers textDocument/definition and textDocument/hover
+// requests using c's Resolver, and uses the contents of open documents
+// (tracked by didOpen/didChange/didClose) instead of the files on disk.
+func serveLSP(c *recycler, in io.Reader, out io.Writer) error {
+	s := &lspServer{
+		c:        c,
+		out:      out,
+		overlays: map[string][]byte{},
+	}
+	br := bufio.NewReader(in)
+	for {
+		body, err := readLSPBody(br)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var msg *lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+type lspServer struct {
+	c   *recycler
+	out io.Writer
+
+	// overlays holds the contents of open documents, keyed by
+	// filename.
+	overlays map[string][]byte
+}
+
+type lspMessage struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes.
+const (
+	lspInvalidParams  = -32602
+	lspMethodNotFound = -32601
+	lspInternalError  = -32603
+	lspRequestFailed  = -32803
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextDocumentPositionParams struct {
+	TextDocument struct {
+		URI string `json:"uri"`
+	} `json:"textDocument"`
+	Position lspPosition `json:"position"`
+}
+
+// readLSPBody reads the body of the next base protocol message.
+func readLSPBody(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || (err == io.ErrUnexpectedEOF && len(header) == 0) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length header: %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (s *lspServer) write(msg map[string]interface{}) error {
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *lspServer) handle(msg *lspMessage) error {
+	result, rerr := s.safeDispatch(msg)
+	if msg.ID == nil {
+		// Notifications get no response.
+		return nil
+	}
+	resp := map[string]interface{}{"id": msg.ID}
+	if rerr != nil {
+		resp["error"] = rerr
+	} else {
+		resp["result"] = result
+	}
+	return s.write(resp)
+}
+
+// safeDispatch is like dispatch, but if answering msg panics, it
+// returns an internal error, so that the session goes on.
+func (s *lspServer) safeDispatch(msg *lspMessage) (result interface{}, rerr *lspError) {
+	defer func() {
+		if v := recover(); v != nil {
+			result, rerr = nil, &lspError{lspInternalError, recoverError(v).Message}
+		}
+	}()
+	return s.dispatch(msg)
+}
+
+func (s *lspServer) dispatch(msg *lspMessage) (interface{}, *lspError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full
+				"definitionProvider": true,
+				"hoverProvider":      true,
+			},
+			"serverInfo": map[string]string{"name": "godefinfo"},
+		}, nil
+
+	case "initialized", "shutdown", "$/cancelRequest":
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		s.overlays[uriToFilename(params.TextDocument.URI)] = []byte(params.TextDocument.Text)
+		return nil, nil
+
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		// We only support full document sync, so the last change
+		// holds the whole document.
+		if n := len(params.ContentChanges); n > 0 {
+			s.overlays[uriToFilename(params.TextDocument.URI)] = []byte(params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		delete(s.overlays, uriToFilename(params.TextDocument.URI))
+		return nil, nil
+
+	case "textDocument/definition", "textDocument/hover":
+		var params lspTextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+		def, err := s.resolve(params)
+		if err != nil {
+			var gerr *godefinfo.Error
+			if errors.As(err, &gerr) {
+				// The cursor isn't on something we can resolve; that's
+				// an empty answer, not a failure.
+				return nil, nil
+			}
+			return nil, &lspError{lspRequestFailed, err.Error()}
+		}
+		if msg.Method == "textDocument/hover" {
+			return map[string]interface{}{
+				"contents": map[string]string{
+					"kind":  "markdown",
+					"value": hoverMarkdown(def),
+				},
+			}, nil
+		}
+		loc, ok := s.location(def)
+		if !ok {
+			return nil, nil
+		}
+		return loc, nil
+	}
+
+	if msg.ID == nil {
+		// Ignore unknown notifications.
+		return nil, nil
+	}
+	return nil, &lspError{lspMethodNotFound, "method not supported: " + msg.Method}
+}
+
+func (s *lspServer) resolve(params lspTextDocumentPositionParams) (*godefinfo.DefInfo, error) {
+	filename := uriToFilename(params.TextDocument.URI)
+	src, err := s.contents(filename)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := godefinfo.OffsetOf(src, params.Position.Line+1, params.Position.Character+1, godefinfo.UTF16)
+	if err != nil {
+		// There is no identifier outside the document.
+		return nil, &godefinfo.Error{Code: godefinfo.ErrNoIdentifier, Message: err.Error()}
+	}
+	q := godefinfo.Query{
+		Filename: filename,
+		Offset:   offset,
+		Overlay:  s.overlays,
+	}
+	return s.c.resolver().Resolve(context.Background(), q)
+}
+
+// location returns the LSP location of def's declaration.
+func (s *lspServer) location(def *godefinfo.DefInfo) (*lspLocation, bool) {
+	if def.Filename == "" || def.Offset == 0 {
+		return nil, false
+	}
+	src, err := s.contents(def.Filename)
+	if err != nil {
+		return nil, false
+	}
+	loc := &lspLocation{URI: filenameToURI(def.Filename)}
+	loc.Range.Start = lspPositionOf(src, def.Offset)
+	loc.Range.End = lspPositionOf(src, def.EndOffset)
+	return loc, true
+}
+
+// hoverMarkdown returns the hover text for def: its signature (or,
+// if it has none, its description) followed by its doc comment.
+func hoverMarkdown(def *godefinfo.DefInfo) string {
+	code := def.Signature
+	if code == "" {
+		code = def.String()
+	}
+	md := "```go\n" + code + "\n```"
+	if def.Doc != "" {
+		md += "\n\n" + def.Doc
+	}
+	return md
+}
+
+// contents returns the contents of the named file, from the open
+// documents if it is open and from disk otherwise.
+func (s *lspServer) contents(filename string) ([]byte, error) {
+	if src, ok := s.overlays[filename]; ok {
+		return src, nil
+	}
+	return ioutil.ReadFile(filename)
+}
+
+func uriToFilename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func filenameToURI(filename string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}).String()
+}
+
+// lspPositionOf returns the LSP position of the 1-based byte offset
+// in src.
+func lspPositionOf(src []byte, offset int) lspPosition {
+	line, column := godefinfo.ColumnOf(src, offset, godefinfo.UTF16)
+	return lspPosition{line - 1, column - 1}
+}
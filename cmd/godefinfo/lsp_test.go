@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sqs/godefinfo"
+)
+
+func TestLSP(t *testing.T) {
+	// The comments contain characters that are 1 and 2 UTF-16 code
+	// units long (and 2 and 4 bytes long).
+	const src = "package p\n\n// F does nothing.\nfunc /*é😀*/ F() {}\n\nfunc init() { /*😀*/ F() }\n"
+	uri := filenameToURI(filepath.Join(t.TempDir(), "a.go"))
+
+	var in bytes.Buffer
+	send := func(id int, method string, params interface{}) {
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+		if id != 0 {
+			msg["id"] = id
+		}
+		body, _ := json.Marshal(msg)
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	pos := map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     lspPosition{Line: 5, Character: 21},
+	}
+	send(1, "initialize", map[string]interface{}{})
+	send(0, "initialized", map[string]interface{}{})
+	send(0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri, "languageId": "go", "text": src},
+	})
+	send(2, "textDocument/definition", pos)
+	send(3, "textDocument/hover", pos)
+	send(4, "shutdown", nil)
+	send(0, "exit", nil)
+
+	var out bytes.Buffer
+	if err := serveLSP(newRecycler(godefinfo.Options{ImportSrc: true, Docs: true}), &in, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	results := map[int]json.RawMessage{}
+	br := bufio.NewReader(&out)
+	for {
+		body, err := readLSPBody(br)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		var resp struct {
+			ID     int
+			Result json.RawMessage
+			Error  *lspError
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Error != nil {
+			t.Errorf("request %d: error: %s", resp.ID, resp.Error.Message)
+		}
+		results[resp.ID] = resp.Result
+	}
+
+	var loc lspLocation
+	if err := json.Unmarshal(results[2], &loc); err != nil {
+		t.Fatal(err)
+	}
+	want := lspLocation{URI: uri, Range: lspRange{Start: lspPosition{3, 13}, End: lspPosition{3, 14}}}
+	if loc != want {
+		t.Errorf("definition: got %+v, want %+v", loc, want)
+	}
+
+	for _, want := range []string{"func F()", "F does nothing."} {
+		if hover := string(results[3]); !strings.Contains(hover, want) {
+			t.Errorf("hover: got %s, want it to contain %q", hover, want)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+// Command godefinfo prints information about the definition of the Go
+// identifier at a location in a source file.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+
+	"github.com/sqs/godefinfo"
+)
+
+var (
+	readStdin = flag.Bool("i", false, "read file from stdin")
+	modified  = flag.Bool("modified", false, "read an archive of modified files from stdin (filename, size in bytes and contents for each file)")
+	offset    = flag.Int("o", -1, "file offset of identifier in stdin")
+	debug     = flag.Bool("debug", false, "debug mode")
+	strict    = flag.Bool("strict", false, "strict mode (all warnings are fatal)")
+	filename  = flag.String("f", "", "Go source filename")
+	pos       = flag.String("pos", "", "position of identifier as `file.go:line:column` (instead of -f and -o)")
+	colEnc    = flag.String("column-encoding", "utf8", "unit of columns in -pos and in output: utf8 (bytes), utf16 or rune")
+	gobuild   = flag.Bool("gobuild", false, "automatically run `go list -export -deps` on the package to compile deps and import them from export data")
+	importsrc = flag.Bool("importsrc", true, "import external Go packages from source (can be slower than -gobuild)")
+	version   = flag.Bool("v", false, "version of godefinfo")
+	tags      = flag.String("tags", "", "comma-separated list of build tags to consider satisfied")
+	goos      = flag.String("goos", "", "target operating system for selecting files (default $GOOS or the host's)")
+	goarch    = flag.String("goarch", "", "target architecture for selecting files (default $GOARCH or the host's)")
+
+	cpuprofile  = flag.String("debug.cpuprofile", "", "write CPU profile to this file")
+	repetitions = flag.Int("debug.repetitions", 1, "repeat this many times to generate better profiles")
+	useJSON     = flag.Bool("json", false, "return JSON structured output")
+	mode        = flag.String("mode", "def", "what to find: def (the definition of the identifier), typedef (the definitions of the named types in the expression's type), refs (the references to the identifier's definition) or implementations (the types or methods that implement an interface or interface method, or the interfaces that a type or method implements)")
+	root        = flag.String("root", "", "with -mode=refs or implementations, the directory whose packages are searched (default the module root or GOPATH src directory)")
+	localType   = flag.Bool("localtype", false, "for a local variable, parameter, etc., print its type instead of its declaration")
+	hover       = flag.Bool("hover", false, "also print the definition's signature and doc comment")
+	lookup      = flag.String("lookup", "", "print the position of the definition with this descriptor (eg \"net/http Response Body\", as printed by godefinfo) instead of resolving an identifier")
+
+	serve    = flag.Bool("serve", false, "serve newline-delimited JSON queries from stdin, writing answers to stdout")
+	lsp      = flag.Bool("lsp", false, "run a Language Server Protocol server (definition and hover) on stdin/stdout")
+	annotate = flag.Bool("annotate", false, "print the definition of every identifier in -f as one JSON object per line")
+	batch    = flag.Bool("batch", false, "read queries (offset or file.go:offset in -f's package) from stdin, one per line, and write one JSON result per line to stdout")
+
+	lsif      = flag.Bool("lsif", false, "write an LSIF dump of the packages matched by the arguments (default ./...) to stdout")
+	indexFile = flag.String("index", "godefinfo.index", "index file written by the index subcommand and read by the query subcommand")
+	ctags     = flag.Bool("ctags", false, "write a ctags file (Exuberant/Universal extended format) of the packages in the argument directories (default .) and below to stdout")
+	etags     = flag.Bool("etags", false, "like -ctags, but in etags format")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: godefinfo [flags]\n")
+		fmt.Fprintf(os.Stderr, "       godefinfo [flags] index [dir]\n")
+		fmt.Fprintf(os.Stderr, "       godefinfo [flags] query [descriptor]\n")
+		fmt.Fprintf(os.Stderr, "       godefinfo -lsif [packages]\n")
+		fmt.Fprintf(os.Stderr, "       godefinfo -ctags|-etags [dirs]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// A subcommand may be followed by more flags.
+	var cmd string
+	listArgs := *lsif || *ctags || *etags
+	if flag.NArg() > 0 && !listArgs {
+		cmd = flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	switch {
+	case cmd == "" && (flag.NArg() == 0 || listArgs):
+	case (cmd == "index" || cmd == "query") && flag.NArg() <= 1:
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	switch *mode {
+	case "def", "typedef", "refs", "implementations":
+		if cmd == "query" && *mode != "def" && *mode != "refs" {
+			log.Fatalf("the query subcommand only supports -mode=def and -mode=refs")
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown -mode %q\n", *mode)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *version {
+		fmt.Printf("godefinfo version 0.1\n")
+		os.Exit(0)
+	}
+	log.SetFlags(0)
+
+	if *cpuprofile != "" {
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		pprof.StartCPUProfile(f)
+		defer pprof.StopCPUProfile()
+	}
+
+	opts := godefinfo.Options{
+		Strict:     *strict,
+		ImportSrc:  *importsrc,
+		GoBuild:    *gobuild,
+		Docs:       *hover || *lsp || *lsif,
+		LocalTypes: *localType,
+		GOOS:       *goos,
+		GOARCH:     *goarch,
+	}
+	if *tags != "" {
+		opts.BuildTags = strings.Split(*tags, ",")
+	}
+	if *debug {
+		opts.DebugLog = log.New(os.Stderr, "[debug] ", 0)
+	}
+
+	if *serve {
+		if err := serveJSON(newRecycler(opts), os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *lsp {
+		if err := serveLSP(newRecycler(opts), os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	enc, err := godefinfo.ParseColumnEncoding(*colEnc)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *lsif {
+		patterns := flag.Args()
+		if len(patterns) == 0 {
+			patterns = []string{"./..."}
+		}
+		if err := godefinfo.NewResolver(opts).WriteLSIF(context.Background(), os.Stdout, ".", patterns); err != nil {
+			exitError(err)
+		}
+		return
+	}
+
+	if *ctags || *etags {
+		if err := writeTags(godefinfo.NewResolver(opts), os.Stdout, flag.Args(), *etags); err != nil {
+			exitError(err)
+		}
+		return
+	}
+
+	if cmd == "index" {
+		dir := "."
+		if flag.NArg() == 1 {
+			dir = flag.Arg(0)
+		}
+		if err := writeIndex(godefinfo.NewResolver(opts), dir, *indexFile); err != nil {
+			exitError(err)
+		}
+		return
+	}
+	if cmd == "query" && flag.NArg() == 1 {
+		ix, err := readIndex(*indexFile)
+		if err != nil {
+			exitError(err)
+		}
+		if err := runQuery(ix, godefinfo.Query{}, flag.Arg(0), enc); err != nil {
+			exitError(err)
+		}
+		return
+	}
+
+	if *lookup != "" {
+		def, err := godefinfo.NewResolver(opts).Lookup(context.Background(), *lookup, ".")
+		if err != nil {
+			exitError(err)
+		}
+		convertColumns(godefinfo.Query{}, def, enc)
+		if *useJSON {
+			outputData(def)
+		} else {
+			outputDefs([]*godefinfo.DefInfo{def}, true)
+		}
+		return
+	}
+
+	q := godefinfo.Query{
+		Filename: *filename,
+		Offset:   *offset,
+	}
+	if *readStdin && *modified {
+		log.Fatal("-i and -modified are mutually exclusive")
+	}
+	if *batch && (*readStdin || *modified) {
+		log.Fatal("-batch reads queries from stdin and can't be used with -i or -modified")
+	}
+	var line, column int
+	if *pos != "" {
+		if *filename != "" || *offset != -1 {
+			log.Fatal("-pos and -f/-o are mutually exclusive")
+		}
+		q.Filename, line, column, err = parsePos(*pos)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *readStdin {
+		q.Src, err = ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			exitError(&godefinfo.Error{Code: godefinfo.ErrIO, Message: "reading stdin", Err: err})
+		}
+	}
+	if *modified {
+		q.Overlay, err = godefinfo.ParseOverlayArchive(os.Stdin)
+		if err != nil {
+			exitError(&godefinfo.Error{Code: godefinfo.ErrParse, Message: "parsing -modified archive", Err: err})
+		}
+	}
+
+	if *annotate {
+		p, err := godefinfo.NewResolver(opts).Check(context.Background(), q)
+		if err != nil {
+			exitError(err)
+		}
+		anns, err := p.Annotate(q.Filename)
+		if err != nil {
+			exitError(err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for _, ann := range anns {
+			if err := enc.Encode(ann); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	if *batch {
+		p, err := godefinfo.NewResolver(opts).Check(context.Background(), q)
+		if err != nil {
+			exitError(err)
+		}
+		if err := runBatch(p.Resolve, os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *pos != "" {
+		src, err := contents(q, q.Filename)
+		if err != nil {
+			exitError(&godefinfo.Error{Code: godefinfo.ErrIO, Message: "reading source file", Err: err})
+		}
+		if q.Offset, err = godefinfo.OffsetOf(src, line, column, enc); err != nil {
+			exitError(&godefinfo.Error{Code: godefinfo.ErrNoIdentifier, Message: *pos, Err: err})
+		}
+	}
+
+	if cmd == "query" {
+		ix, err := readIndex(*indexFile)
+		if err != nil {
+			exitError(err)
+		}
+		if err := runQuery(ix, q, "", enc); err != nil {
+			exitError(err)
+		}
+		return
+	}
+
+	if *mode == "typedef" || *mode == "implementations" {
+		p, err := godefinfo.NewResolver(opts).Check(context.Background(), q)
+		if err != nil {
+			exitError(err)
+		}
+		var defs []*godefinfo.DefInfo
+		if *mode == "typedef" {
+			defs, err = p.TypeDefinitions(q.Filename, q.Offset)
+		} else {
+			defs, err = p.Implementations(context.Background(), q.Filename, q.Offset, *root)
+		}
+		if err != nil {
+			exitError(err)
+		}
+		for _, def := range defs {
+			convertColumns(q, def, enc)
+		}
+		outputDefs(defs, *mode == "implementations")
+		return
+	}
+
+	if *mode == "refs" {
+		p, err := godefinfo.NewResolver(opts).Check(context.Background(), q)
+		if err != nil {
+			exitError(err)
+		}
+		refs, err := p.References(context.Background(), q.Filename, q.Offset, *root)
+		if err != nil {
+			exitError(err)
+		}
+		outputRefs(q, refs, enc)
+		return
+	}
+
+	var def *godefinfo.DefInfo
+	for i := 0; i < *repetitions; i++ {
+		def, err = godefinfo.NewResolver(opts).Resolve(context.Background(), q)
+		if err != nil {
+			exitError(err)
+		}
+	}
+	convertColumns(q, def, enc)
+	outputData(def)
+}
+
+// convertColumns converts def's column from bytes to enc.
+func convertColumns(q godefinfo.Query, def *godefinfo.DefInfo, enc godefinfo.ColumnEncoding) {
+	if enc != godefinfo.UTF8 && def.Offset != 0 {
+		if src, err := contents(q, def.Filename); err == nil {
+			def.Line, def.Column = godefinfo.ColumnOf(src, def.Offset, enc)
+		}
+	}
+}
+
+// parsePos parses a position of the form "file.go:line:column".
+func parsePos(pos string) (filename string, line, column int, err error) {
+	i := strings.LastIndex(pos, ":")
+	j := -1
+	if i > 0 {
+		j = strings.LastIndex(pos[:i], ":")
+	}
+	if j <= 0 {
+		return "", 0, 0, fmt.Errorf("bad position %q (want file.go:line:column)", pos)
+	}
+	line, err1 := strconv.Atoi(pos[j+1 : i])
+	column, err2 := strconv.Atoi(pos[i+1:])
+	if err1 != nil || err2 != nil {
+		return "", 0, 0, fmt.Errorf("bad position %q (want file.go:line:column)", pos)
+	}
+	return pos[:j], line, column, nil
+}
+
+// contents returns the contents of the named file, taking the query's
+// source and overlay into account.
+func contents(q godefinfo.Query, filename string) ([]byte, error) {
+	filename = absPath(filename)
+	if q.Src != nil && filename == absPath(q.Filename) {
+		return q.Src, nil
+	}
+	for name, src := range q.Overlay {
+		if filename == absPath(name) {
+			return src, nil
+		}
+	}
+	return ioutil.ReadFile(filename)
+}
+
+func absPath(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filename
+}
+
+// jsonDef is the JSON output for a definition.
+type jsonDef struct {
+	SchemaVersion int
+	*godefinfo.DefInfo
+}
+
+// outputDefs prints defs, one per line (or as a JSON array with
+// -json). If positions is set, each line begins with the definition's
+// file:line:column.
+func outputDefs(defs []*godefinfo.DefInfo, positions bool) {
+	if !*useJSON {
+		for _, def := range defs {
+			if positions && def.Filename != "" {
+				fmt.Printf("%s:%d:%d: %s\n", def.Filename, def.Line, def.Column, def)
+			} else {
+				fmt.Println(def)
+			}
+		}
+		return
+	}
+	out := make([]jsonDef, len(defs))
+	for i, def := range defs {
+		out[i] = jsonDef{godefinfo.SchemaVersion, def}
+	}
+	bytes, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(bytes)
+}
+
+// outputRefs prints refs as file:line:column-endcolumn ranges, one
+// per line (or as a JSON array with -json), with columns in enc.
+func outputRefs(q godefinfo.Query, refs []*godefinfo.Reference, enc godefinfo.ColumnEncoding) {
+	if enc != godefinfo.UTF8 {
+		for _, ref := range refs {
+			if src, err := contents(q, ref.Filename); err == nil {
+				ref.Line, ref.Column = godefinfo.ColumnOf(src, ref.Offset, enc)
+				_, ref.EndColumn = godefinfo.ColumnOf(src, ref.EndOffset, enc)
+			}
+		}
+	}
+	if !*useJSON {
+		for _, ref := range refs {
+			fmt.Printf("%s:%d:%d-%d\n", ref.Filename, ref.Line, ref.Column, ref.EndColumn)
+		}
+		return
+	}
+	if refs == nil {
+		refs = []*godefinfo.Reference{}
+	}
+	bytes, err := json.MarshalIndent(refs, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(bytes)
+}
+
+func outputData(def *godefinfo.DefInfo) {
+	if !*useJSON {
+		fmt.Println(def)
+		if *hover {
+			if def.Signature != "" {
+				fmt.Println(def.Signature)
+			}
+			if def.Doc != "" {
+				fmt.Printf("\n%s", def.Doc)
+			}
+		}
+		return
+	}
+	out := jsonDef{godefinfo.SchemaVersion, def}
+	bytes, err := json.MarshalIndent(out, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(bytes)
+}
+
+// exitCodes maps error codes to process exit codes. Exit status 1 is
+// used for errors without a code and 2 for usage errors.
+var exitCodes = map[godefinfo.ErrorCode]int{
+	godefinfo.ErrNoIdentifier: 3,
+	godefinfo.ErrNoTypeInfo:   4,
+	godefinfo.ErrNotFound:     5,
+	godefinfo.ErrUnsupported:  6,
+	godefinfo.ErrParse:        7,
+	godefinfo.ErrTypeCheck:    8,
+	godefinfo.ErrIO:           9,
+}
+
+// jsonError is the JSON representation of an error.
+type jsonError struct {
+	Code    godefinfo.ErrorCode `json:",omitempty"`
+	Message string
+}
+
+func newJSONError(err error) *jsonError {
+	e := &jsonError{Message: err.Error()}
+	var gerr *godefinfo.Error
+	if errors.As(err, &gerr) {
+		e.Code = gerr.Code
+	}
+	return e
+}
+
+// errInternal is the code of the error that -serve and -lsp report for
+// a request whose answer panicked.
+const errInternal godefinfo.ErrorCode = "internal"
+
+// recoverError returns the error for v, a value recovered from a panic
+// while answering a request, after logging the stack on stderr.
+func recoverError(v interface{}) *jsonError {
+	stack := make([]byte, 64<<10)
+	stack = stack[:runtime.Stack(stack, false)]
+	log.Printf("panic: %v\n%s", v, stack)
+	return &jsonError{Code: errInternal, Message: fmt.Sprintf("internal error: %v", v)}
+}
+
+// exitError reports err on stderr (as a JSON object if -json is set)
+// and exits with the exit code for its error code.
+func exitError(err error) {
+	jerr := newJSONError(err)
+	exitCode := 1
+	if c, ok := exitCodes[jerr.Code]; ok {
+		exitCode = c
+	}
+
+	if *useJSON {
+		json.NewEncoder(os.Stderr).Encode(jerr)
+	} else {
+		log.Print(err)
+	}
+	os.Exit(exitCode)
+}
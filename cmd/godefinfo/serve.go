@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/sqs/godefinfo"
+)
+
+// serveRequest is a query read by -serve mode.
+type serveRequest struct {
+	// ID is echoed back in the response so that clients can match
+	// responses (which may arrive out of order) to requests.
+	ID json.RawMessage `json:",omitempty"`
+
+	Filename string
+	Offset   int
+
+	// Contents, if set, is used instead of the file's contents on
+	// disk.
+	Contents *string `json:",omitempty"`
+
+	// Overlay maps filenames to contents that are used instead of
+	// the contents on disk (eg, for unsaved files in the editor).
+	Overlay map[string]string `json:",omitempty"`
+}
+
+// serveResponse is the answer to a serveRequest. Exactly one of Def
+// and Error is set.
+type serveResponse struct {
+	ID    json.RawMessage    `json:",omitempty"`
+	Def   *godefinfo.DefInfo `json:",omitempty"`
+	Error *jsonError         `json:",omitempty"`
+}
+
+// maxReparses is how many changed files a Resolver used by -serve or
+// -lsp may reparse before it is replaced.
+const maxReparses = 1000
+
+// A recycler provides the Resolver for each request of a long-running
+// server. A Resolver's file set grows with every file it reparses (eg,
+// on each edit of an open document), so once it has reparsed
+// maxReparses files the recycler replaces it with a new one. Requests
+// in flight keep using the Resolver they started with.
+type recycler struct {
+	opts        godefinfo.Options
+	maxReparses int
+
+	mu sync.Mutex
+	r  *godefinfo.Resolver
+}
+
+func newRecycler(opts godefinfo.Options) *recycler {
+	return &recycler{opts: opts, maxReparses: maxReparses, r: godefinfo.NewResolver(opts)}
+}
+
+// resolver returns the Resolver to answer a request with.
+func (c *recycler) resolver() *godefinfo.Resolver {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.r.ParseStats().Reparses >= c.maxReparses {
+		c.r = godefinfo.NewResolver(c.opts)
+	}
+	return c.r
+}
+
+// serveJSON reads newline-delimited JSON requests from in and writes
+// one JSON response line per request to out. Requests are answered
+// concurrently by c's Resolver, which keeps imported packages and
+// parsed files warm between requests. It returns when in is exhausted
+// and all responses have been written.
+func serveJSON(c *recycler, in io.Reader, out io.Writer) error {
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex // guards enc
+		enc = json.NewEncoder(out)
+	)
+	defer wg.Wait()
+
+	dec := json.NewDecoder(in)
+	for {
+		var req serveRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q := godefinfo.Query{Filename: req.Filename, Offset: req.Offset}
+			if req.Contents != nil {
+				q.Src = []byte(*req.Contents)
+			}
+			if len(req.Overlay) > 0 {
+				q.Overlay = make(map[string][]byte, len(req.Overlay))
+				for name, src := range req.Overlay {
+					q.Overlay[name] = []byte(src)
+				}
+			}
+			resp := serveResponse{ID: req.ID}
+			defer func() {
+				// A panic fails only this request, not the server.
+				if v := recover(); v != nil {
+					resp = serveResponse{ID: req.ID, Error: recoverError(v)}
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				enc.Encode(resp)
+			}()
+			def, err := c.resolver().Resolve(context.Background(), q)
+			if err != nil {
+				resp.Error = newJSONError(err)
+			} else {
+				resp.Def = def
+			}
+		}()
+	}
+}
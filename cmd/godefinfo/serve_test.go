@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sqs/godefinfo"
+)
+
+func TestServeJSON(t *testing.T) {
+	const src = "package p\n\nfunc F() { F() }\n"
+	c := newRecycler(godefinfo.Options{ImportSrc: true})
+	serve := func(reqs ...serveRequest) map[string]serveResponse {
+		t.Helper()
+		var in bytes.Buffer
+		enc := json.NewEncoder(&in)
+		for _, req := range reqs {
+			enc.Encode(req)
+		}
+		var out bytes.Buffer
+		if err := serveJSON(c, &in, &out); err != nil {
+			t.Fatal(err)
+		}
+		resps := map[string]serveResponse{}
+		dec := json.NewDecoder(&out)
+		for dec.More() {
+			var resp serveResponse
+			if err := dec.Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			resps[string(resp.ID)] = resp
+		}
+		return resps
+	}
+	query := func(id, src string, offset int) serveRequest {
+		return serveRequest{ID: json.RawMessage(id), Filename: "/tmp/godef_serve.go", Offset: offset, Contents: &src}
+	}
+
+	resps := serve(query(`1`, src, strings.Index(src, "F()")+1), query(`2`, src, 1))
+	if got := resps["1"].Def; got == nil || got.String() != "p F" {
+		t.Errorf("request 1: got def %v, want %q", got, "p F")
+	}
+	if got := resps["2"].Error; got == nil || got.Code != godefinfo.ErrNoIdentifier {
+		t.Errorf("request 2: got error %+v, want code %q", got, godefinfo.ErrNoIdentifier)
+	}
+
+	// Another query of the same contents doesn't parse them again.
+	r := c.resolver()
+	parsed := r.ParseStats().Files
+	resps = serve(query(`3`, src, strings.Index(src, "F()")+1))
+	if got := resps["3"].Def; got == nil || got.String() != "p F" {
+		t.Errorf("request 3: got def %v, want %q", got, "p F")
+	}
+	if got := r.ParseStats().Files; got != parsed {
+		t.Errorf("request 3: got %d files parsed, want %d", got, parsed)
+	}
+	if c.resolver() != r {
+		t.Error("request 3: Resolver was replaced")
+	}
+
+	// Once the Resolver has reparsed maxReparses changed files, the
+	// next request gets a new one.
+	c.maxReparses = r.ParseStats().Reparses + 1
+	const changed = "package p\n\nfunc F() { F(); F() }\n"
+	resps = serve(query(`4`, changed, strings.LastIndex(changed, "F()")+1))
+	if got := resps["4"].Def; got == nil || got.String() != "p F" {
+		t.Errorf("request 4: got def %v, want %q", got, "p F")
+	}
+	if c.resolver() == r {
+		t.Error("request 4: Resolver was not replaced after reparsing a changed file")
+	}
+}
+
+func TestRecycler(t *testing.T) {
+	c := newRecycler(godefinfo.Options{ImportSrc: true})
+	c.maxReparses = 2
+	filename := filepath.Join(t.TempDir(), "p.go")
+	resolve := func(src string) *godefinfo.Resolver {
+		t.Helper()
+		r := c.resolver()
+		q := godefinfo.Query{Filename: filename, Src: []byte(src), Offset: strings.Index(src, "F()") + 1}
+		if _, err := r.Resolve(context.Background(), q); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	const a, b = "package p\n\nfunc F() { F() }\n", "package p\n\nfunc F() {\n\tF()\n}\n"
+
+	// The first parse and the two reparses are done by one Resolver.
+	r := resolve(a)
+	for i, src := range []string{b, a} {
+		if got := resolve(src); got != r {
+			t.Fatalf("reparse %d: Resolver was replaced early", i+1)
+		}
+	}
+	if got := r.ParseStats().Reparses; got != 2 {
+		t.Fatalf("got %d reparses, want 2", got)
+	}
+
+	// The next request gets a new Resolver, which starts afresh.
+	r2 := resolve(b)
+	if r2 == r {
+		t.Fatal("Resolver was not replaced after maxReparses reparses")
+	}
+	if got := r2.ParseStats(); got.Files != 1 || got.Reparses != 0 {
+		t.Errorf("new Resolver: got %+v, want 1 file parsed and no reparses", got)
+	}
+}
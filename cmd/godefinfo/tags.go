@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sqs/godefinfo"
+)
+
+// writeTags writes a tags file of the packages in dirs (or in the
+// current directory, if dirs is empty) to w, in etags format if etags
+// is set and in Exuberant/Universal ctags format otherwise. Filenames
+// are relative to the current directory, where the tags file is
+// expected to be.
+func writeTags(r *godefinfo.Resolver, w io.Writer, dirs []string, etags bool) error {
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	var tags []*godefinfo.Tag
+	for _, dir := range dirs {
+		t, err := r.Tags(context.Background(), dir)
+		if err != nil {
+			return err
+		}
+		tags = append(tags, t...)
+	}
+	wd, err := filepath.Abs(".")
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if etags {
+		writeEtags(bw, tags, wd)
+	} else {
+		writeCtags(bw, tags, wd)
+	}
+	return bw.Flush()
+}
+
+// writeCtags writes tags in the extended ctags format, sorted by name,
+// with filenames relative to dir. Each tag has its kind and line, the
+// kind and name of the type that a method or field belongs to as its
+// scope, and the parameters and results of a func or method as its
+// signature.
+func writeCtags(w io.Writer, tags []*godefinfo.Tag, dir string) {
+	tags = append([]*godefinfo.Tag(nil), tags...)
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, b := tags[i], tags[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Line < b.Line
+	})
+
+	fmt.Fprintf(w, "!_TAG_FILE_FORMAT\t2\t/extended format; --format=1 will not append ;\" to lines/\n")
+	fmt.Fprintf(w, "!_TAG_FILE_SORTED\t1\t/0=unsorted, 1=sorted, 2=foldcase/\n")
+	fmt.Fprintf(w, "!_TAG_PROGRAM_NAME\tgodefinfo\t//\n")
+	for _, t := range tags {
+		pattern := strings.NewReplacer(`\`, `\\`, `/`, `\/`).Replace(t.Text)
+		fmt.Fprintf(w, "%s\t%s\t/^%s$/;\"\t%s\tline:%d", t.Name, relPath(dir, t.Filename), pattern, ctagsKind(t), t.Line)
+		if t.Container != "" {
+			fmt.Fprintf(w, "\t%s:%s", t.ContainerKind, t.Container)
+		}
+		if t.Signature != "" {
+			fmt.Fprintf(w, "\tsignature:%s", t.Signature)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// ctagsKind returns the letter of t's kind that Universal ctags uses
+// for Go.
+func ctagsKind(t *godefinfo.Tag) string {
+	switch t.Kind {
+	case godefinfo.KindFunc:
+		return "f"
+	case godefinfo.KindMethod:
+		if t.ContainerKind == "interface" {
+			return "n"
+		}
+		return "f"
+	case godefinfo.KindField:
+		return "m"
+	case godefinfo.KindVar:
+		return "v"
+	case godefinfo.KindConst:
+		return "c"
+	case godefinfo.KindType:
+		switch t.TypeKind {
+		case "struct":
+			return "s"
+		case "interface":
+			return "i"
+		case "alias":
+			return "a"
+		}
+		return "t"
+	}
+	return "v"
+}
+
+// writeEtags writes tags in etags format, in file order, with filenames
+// relative to dir: a section for each file, listing the start of the
+// line up to the end of the name, the name, and the line and the byte
+// offset of the line for each tag.
+func writeEtags(w io.Writer, tags []*godefinfo.Tag, dir string) {
+	var (
+		filename string
+		section  bytes.Buffer
+	)
+	flush := func() {
+		if section.Len() > 0 {
+			fmt.Fprintf(w, "\x0c\n%s,%d\n", relPath(dir, filename), section.Len())
+			section.WriteTo(w)
+		}
+	}
+	for _, t := range tags {
+		if t.Filename != filename {
+			flush()
+			filename = t.Filename
+		}
+		text := t.Text
+		if end := t.Column - 1 + len(t.Name); end <= len(text) {
+			text = text[:end]
+		}
+		fmt.Fprintf(&section, "%s\x7f%s\x01%d,%d\n", text, t.Name, t.Line, t.Offset-t.Column)
+	}
+	flush()
+}
+
+// relPath returns filename relative to dir, or filename itself if it
+// is not in dir.
+func relPath(dir, filename string) string {
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filename
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/sqs/godefinfo"
+)
+
+var testTags = []*godefinfo.Tag{
+	{Name: "T", Kind: godefinfo.KindType, TypeKind: "struct", Filename: "/src/p/p.go", Line: 3, Column: 6, Offset: 16, Text: "type T struct{ a/b int }"},
+	{Name: "M", Container: "T", ContainerKind: "struct", Kind: godefinfo.KindMethod, Signature: "(x int) error", Filename: "/src/p/p.go", Line: 5, Column: 10, Offset: 50, Text: `func (T) M(x int) error { return nil } // \`},
+	{Name: "R", Container: "I", ContainerKind: "interface", Kind: godefinfo.KindMethod, Signature: "()", Filename: "/src/p/q.go", Line: 4, Column: 2, Offset: 32, Text: "\tR()"},
+}
+
+func TestWriteCtags(t *testing.T) {
+	var buf bytes.Buffer
+	writeCtags(&buf, testTags, "/src")
+	want := "!_TAG_FILE_FORMAT\t2\t/extended format; --format=1 will not append ;\" to lines/\n" +
+		"!_TAG_FILE_SORTED\t1\t/0=unsorted, 1=sorted, 2=foldcase/\n" +
+		"!_TAG_PROGRAM_NAME\tgodefinfo\t//\n" +
+		"M\tp/p.go\t/^func (T) M(x int) error { return nil } \\/\\/ \\\\$/;\"\tf\tline:5\tstruct:T\tsignature:(x int) error\n" +
+		"R\tp/q.go\t/^\tR()$/;\"\tn\tline:4\tinterface:I\tsignature:()\n" +
+		"T\tp/p.go\t/^type T struct{ a\\/b int }$/;\"\ts\tline:3\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteEtags(t *testing.T) {
+	var buf bytes.Buffer
+	writeEtags(&buf, testTags, "/src")
+	want := "\x0c\np/p.go,32\n" +
+		"type T\x7fT\x013,10\n" +
+		"func (T) M\x7fM\x015,40\n" +
+		"\x0c\np/q.go,10\n" +
+		"\tR\x7fR\x014,30\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package godefinfo
+
+import "fmt"
+
+// ErrorCode identifies the kind of failure described by an Error.
+// The string values are stable and may be relied upon by callers.
+type ErrorCode string
+
+const (
+	// ErrNoIdentifier means that the query position is not on an
+	// identifier (eg, it is on whitespace, a literal or a keyword).
+	ErrNoIdentifier ErrorCode = "no_identifier"
+
+	// ErrNoTypeInfo means that the identifier was found but the type
+	// checker recorded no object for it, usually because type
+	// checking the package failed.
+	ErrNoTypeInfo ErrorCode = "no_type_info"
+
+	// ErrNotFound means that the identifier refers to a method, field
+	// or selector whose definition could not be located.
+	ErrNotFound ErrorCode = "not_found"
+
+	// ErrUnsupported means that the identifier is in a construct that
+	// godefinfo does not know how to describe (eg, a method on a type
+	// that is not a top-level named type).
+	ErrUnsupported ErrorCode = "unsupported"
+
+	// ErrParse means that the source file could not be parsed.
+	ErrParse ErrorCode = "parse"
+
+	// ErrTypeCheck means that type checking failed in strict mode.
+	ErrTypeCheck ErrorCode = "type_check"
+
+	// ErrIO means that a file could not be read.
+	ErrIO ErrorCode = "io"
+)
+
+// Error is the error type returned by Resolve.
+type Error struct {
+	Code    ErrorCode
+	Message string
+
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func errorf(code ErrorCode, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func wrapError(code ErrorCode, msg string, err error) error {
+	return &Error{Code: code, Message: msg, Err: err}
+}
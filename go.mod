@@ -0,0 +1,3 @@
+module github.com/sqs/godefinfo
+
+go 1.22
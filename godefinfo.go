@@ -1,158 +1,161 @@
-package main
+// Package godefinfo finds information about the definition of the Go
+// identifier at a given location in a source file: the package import
+// path, parent type name (for methods and fields), and name.
+package godefinfo
 
 import (
-	"flag"
-	"fmt"
+	"bytes"
+	"context"
 	"go/ast"
 	"go/build"
 	"go/importer"
-	"go/parser"
+	"go/scanner"
 	"go/token"
 	"go/types"
 	"io/ioutil"
 	"log"
-	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime/pprof"
 	"sort"
 	"strconv"
 	"strings"
-	"time"
+	"sync"
 )
 
-var (
-	readStdin = flag.Bool("i", false, "read file from stdin")
-	offset    = flag.Int("o", -1, "file offset of identifier in stdin")
-	debug     = flag.Bool("debug", false, "debug mode")
-	strict    = flag.Bool("strict", false, "strict mode (all warnings are fatal)")
-	filename  = flag.String("f", "", "Go source filename")
-	gobuild   = flag.Bool("gobuild", false, "automatically run `go build -i` on the filename to rebuild deps (necessary for cross-package lookups)")
-	importsrc = flag.Bool("importsrc", true, "import external Go packages from source (can be slower than -gobuild)")
-	version   = flag.Bool("v", false, "version of godefinfo")
-
-	cpuprofile  = flag.String("debug.cpuprofile", "", "write CPU profile to this file")
-	repetitions = flag.Int("debug.repetitions", 1, "repeat this many times to generate better profiles")
-	useJSON     = flag.Bool("json", false, "return JSON structured output")
-)
+// Query describes the identifier to resolve.
+type Query struct {
+	// Filename is the Go source file that contains the identifier.
+	Filename string
+
+	// Src, if non-nil, is used as the contents of Filename instead
+	// of reading it from disk.
+	Src []byte
+
+	// Offset is the 1-based byte offset of the identifier in the
+	// file (the first byte of the file is at offset 1).
+	Offset int
 
-var (
+	// Overlay maps filenames to contents that are used instead of
+	// the files' contents on disk, both for the package containing
+	// Filename and for imported packages. Overlaid files need not
+	// exist on disk. Src, if set, takes precedence for Filename.
+	Overlay map[string][]byte
+}
+
+// Options configures a Resolver.
+type Options struct {
+	// Strict makes all warnings (parse and type errors) fatal.
+	Strict bool
+
+	// ImportSrc imports external Go packages from source when
+	// compiled export data is not available.
+	ImportSrc bool
+
+	// GoBuild runs `go list -export -deps` on the package before
+	// type checking, which compiles its dependencies so that they can
+	// be imported from export data instead of from source.
+	GoBuild bool
+
+	// LocalTypes makes Resolve report the type of a local variable
+	// (or parameter, etc.) instead of its declaration, as godefinfo
+	// originally did.
+	LocalTypes bool
+
+	// Docs makes Resolve set DefInfo.Doc to the doc comment of the
+	// definition's declaration, which may require parsing its source
+	// file.
+	Docs bool
+
+	// GOOS, GOARCH and BuildTags select which files make up each
+	// package, as with the go command's GOOS and GOARCH environment
+	// variables and -tags flag. If GOOS or GOARCH is empty, the
+	// host's is used.
+	GOOS, GOARCH string
+	BuildTags    []string
+
+	// DebugLog, if non-nil, receives debug output.
+	DebugLog *log.Logger
+}
+
+// A Resolver finds the definitions of identifiers. It owns the file
+// set, importer, parsed files and cache of source-imported packages
+// used by its queries, so that work done by one query is reused by
+// the next.
+//
+// A Resolver is safe for concurrent use by multiple goroutines.
+type Resolver struct {
+	opts Options
 	fset *token.FileSet
 	dlog *log.Logger
-)
 
-func ignoreError(err error) bool {
-	// don't treat "value of ____ is not used" as fatal
-	return err == nil || strings.Contains(err.Error(), "is not used")
+	systemImp types.ImporterFrom
+	cache     *importCache
+	files     *fileCache
+
+	exportsMu sync.Mutex
+	exports   map[string]string // import path -> export data file (with GoBuild)
 }
 
-func main() {
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: godefinfo [flags]\n")
-		flag.PrintDefaults()
-	}
-	flag.Parse()
-	if flag.NArg() != 0 {
-		flag.Usage()
-		os.Exit(2)
+// NewResolver returns a new Resolver configured by opts.
+func NewResolver(opts Options) *Resolver {
+	r := &Resolver{
+		opts:  opts,
+		fset:  token.NewFileSet(),
+		dlog:  opts.DebugLog,
+		cache: newImportCache(),
+		files: newFileCache(),
 	}
-	if *version {
-		fmt.Printf("godefinfo version 0.1\n")
-		os.Exit(0)
+	if r.dlog == nil {
+		r.dlog = log.New(ioutil.Discard, "", 0)
 	}
-
-	if *cpuprofile != "" {
-		f, err := os.Create(*cpuprofile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer f.Close()
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
+	var lookup importer.Lookup
+	if opts.GoBuild {
+		lookup = r.lookupExport
 	}
-repeat:
-	fset = token.NewFileSet()
+	r.systemImp = &lockedImporter{imp: importer.ForCompiler(r.fset, "gc", lookup).(types.ImporterFrom)}
+	return r
+}
 
-	if *debug {
-		dlog = log.New(os.Stderr, "[debug] ", 0)
-	} else {
-		dlog = log.New(ioutil.Discard, "", 0)
-	}
-	log.SetFlags(0)
+func ignoreError(err error) bool {
+	// don't treat "value of ____ is not used" as fatal
+	return err == nil || strings.Contains(err.Error(), "is not used")
+}
 
-	var src []byte
-	if *readStdin {
-		var err error
-		src, err = ioutil.ReadAll(os.Stdin)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
+// Resolve finds the definition of the identifier described by q using
+// a new Resolver that imports packages from source. If the definition
+// can't be found, the error is an *Error describing why (or the
+// context's error, if ctx is done).
+func Resolve(ctx context.Context, q Query) (*DefInfo, error) {
+	return NewResolver(Options{ImportSrc: true}).Resolve(ctx, q)
+}
 
-	pkgFiles, err := parsePackage(*filename, src)
+// Resolve finds the definition of the identifier described by q. See
+// the package-level Resolve function for details.
+func (r *Resolver) Resolve(ctx context.Context, q Query) (*DefInfo, error) {
+	p, err := r.Check(ctx, q)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	var importPath string
-	if *filename != "" {
-		buildPkg, err := build.ImportDir(filepath.Dir(*filename), build.FindOnly|build.AllowBinary)
-		if err != nil {
-			dlog.Println("build.ImportDir:", err)
-		}
-		importPath = buildPkg.ImportPath
-	}
-
-	if *gobuild {
-		t1 := time.Now()
-		if importPath != "" {
-			// Generates the .a files that the importer.Default() must
-			// have to import other packages.
-			if err := exec.Command("go", "build", "-i", importPath).Run(); err != nil {
-				dlog.Println("go build:", err)
-			}
-			dlog.Println("go build took", time.Since(t1))
-		}
+		return nil, err
 	}
+	return p.Resolve(q.Filename, q.Offset)
+}
 
-	if importPath == "" || importPath == "." {
-		importPath = pkgFiles[0].Name.Name
-	}
+// resolve finds the definition of the identifier at the 1-based byte
+// offset in file, one of p's files.
+func (p *Package) resolve(file *ast.File, offset int) (*DefInfo, error) {
+	info, pkg, pkgFiles := p.info, p.pkg, p.files
 
-	conf := types.Config{
-		Importer:                 makeImporter(),
-		FakeImportC:              true,
-		DisableUnusedImportCheck: true,
-		Error: func(error) {},
-	}
-	info := types.Info{
-		Defs:       map[*ast.Ident]types.Object{},
-		Uses:       map[*ast.Ident]types.Object{},
-		Selections: map[*ast.SelectorExpr]*types.Selection{},
-	}
-	pkg, err := conf.Check(importPath, fset, pkgFiles, &info)
-	if err != nil && !ignoreError(err) {
-		if *strict {
-			log.Fatal(err)
-		}
-		dlog.Println(err)
+	nodes, err := p.pathAt(file, offset)
+	if err != nil {
+		return nil, err
 	}
 
-	pos := token.Pos(*offset)
-	nodes, _ := pathEnclosingInterval(pkgFiles[0], pos, pos)
-
 	// Handle import statements.
-	//
-	// TODO(sqs): fix this control flow so that the -debug.repetitions
-	// flag causes this code path to repeat as well.
 	if len(nodes) > 2 {
 		if im, ok := nodes[1].(*ast.ImportSpec); ok {
 			pkgPath, err := strconv.Unquote(im.Path.Value)
 			if err != nil {
-				log.Fatal(err)
+				return nil, wrapError(ErrParse, "bad import path", err)
 			}
-			outputData(pkgPath)
-			return
+			return newDefInfo(pkgPath), nil
 		}
 	}
 
@@ -164,7 +167,7 @@ repeat:
 	} else {
 		identX, ok = nodes[0].(*ast.Ident)
 		if !ok {
-			log.Fatal("no identifier found")
+			return nil, errorf(ErrNoIdentifier, "no identifier found")
 		}
 		if len(nodes) > 1 {
 			selX, _ = nodes[1].(*ast.SelectorExpr)
@@ -172,161 +175,164 @@ repeat:
 	}
 
 	if obj := info.Defs[identX]; obj != nil {
+		if def, ok := typeParamInfo(obj, pkgFiles); ok {
+			// Type parameter of a generic func, type or method.
+			return def, nil
+		}
+
+		if isLocal(obj) && !p.r.opts.LocalTypes {
+			return localInfo(obj, pkgFiles), nil
+		}
+
 		switch t := obj.Type().(type) {
 		case *types.Signature:
 			if t.Recv() == nil {
 				// Top-level func.
-				outputData(objectString(obj))
-			} else {
-				// Method or interface method.
-				outputData(obj.Pkg().Path(), dereferenceType(t.Recv().Type()).(*types.Named).Obj().Name(), identX.Name)
+				return objectInfo(obj), nil
 			}
-			return
+			// Method or interface method.
+			recv, ok := dereferenceType(t.Recv().Type()).(*types.Named)
+			if !ok {
+				// Method of an anonymous interface, as in "var x
+				// interface{ M() }".
+				return nil, errorf(ErrUnsupported, "receiver is not a top-level named type")
+			}
+			return newDefInfo(obj.Pkg().Path(), recv.Obj().Name(), identX.Name).withObject(obj), nil
 		}
 
 		if obj.Parent() == pkg.Scope() {
 			// Top-level package def.
-			outputData(objectString(obj))
-			return
+			return objectInfo(obj), nil
 		}
 
 		// Struct field.
-		if _, ok := nodes[1].(*ast.Field); ok {
-			if typ, ok := nodes[4].(*ast.TypeSpec); ok {
-				outputData(obj.Pkg().Path(), typ.Name.Name, obj.Name())
-				return
+		if len(nodes) > 4 {
+			if _, ok := nodes[1].(*ast.Field); ok {
+				if typ, ok := nodes[4].(*ast.TypeSpec); ok {
+					return newDefInfo(obj.Pkg().Path(), typ.Name.Name, obj.Name()).withObject(obj), nil
+				}
 			}
 		}
 
-		if pkg, name, ok := typeName(dereferenceType(obj.Type())); ok {
-			outputData(pkg, name)
-			return
+		if def, ok := typeInfo(dereferenceType(obj.Type()), pkgFiles); ok {
+			return def, nil
 		}
 
-		log.Fatalf("unable to identify def (ident: %v, object: %v)", identX, obj)
-		return
+		return nil, errorf(ErrUnsupported, "unable to identify def (ident: %v, object: %v)", identX, obj)
 	}
 
 	obj := info.Uses[identX]
+	if obj == nil && !p.r.opts.LocalTypes {
+		// The symbolic variable of a type switch (x in "switch x :=
+		// y.(type)") is declared implicitly in each clause.
+		obj = typeSwitchVar(info, nodes, identX)
+	}
 	if obj == nil {
-		log.Fatalf("no type information for identifier %q at %d", identX.Name, *offset)
+		return nil, errorf(ErrNoTypeInfo, "no type information for identifier %q at %d", identX.Name, offset)
+	}
+	obj = originObject(obj)
+
+	if def, ok := typeParamInfo(obj, pkgFiles); ok {
+		return def, nil
+	}
+
+	if isLocal(obj) && !p.r.opts.LocalTypes {
+		return localInfo(obj, pkgFiles), nil
 	}
 
 	if obj, ok := obj.(*types.Var); ok && obj.IsField() {
 		// Struct literal
-		if lit, ok := nodes[2].(*ast.CompositeLit); ok {
-			if parent, ok := lit.Type.(*ast.SelectorExpr); ok {
-				outputData(obj.Pkg().Path(), parent.Sel, obj.Id())
-				return
-			} else if parent, ok := lit.Type.(*ast.Ident); ok {
-				outputData(obj.Pkg().Path(), parent, obj.Id())
-				return
+		if len(nodes) > 2 {
+			if lit, ok := nodes[2].(*ast.CompositeLit); ok {
+				switch parent := unindexExpr(lit.Type).(type) {
+				case *ast.SelectorExpr:
+					return newDefInfo(obj.Pkg().Path(), parent.Sel.Name, obj.Name()).withObject(obj), nil
+				case *ast.Ident:
+					return newDefInfo(obj.Pkg().Path(), parent.Name, obj.Name()).withObject(obj), nil
+				}
 			}
 		}
 	}
 
 	if pkgName, ok := obj.(*types.PkgName); ok {
-		outputData(pkgName.Imported().Path())
+		return newDefInfo(pkgName.Imported().Path()), nil
 	} else if selX == nil {
 		if pkg.Scope().Lookup(identX.Name) == obj {
-			outputData(objectString(obj))
+			return objectInfo(obj), nil
 		} else if types.Universe.Lookup(identX.Name) == obj {
-			outputData("builtin", obj.Name())
-		} else {
-			t := dereferenceType(obj.Type())
-			if pkg, name, ok := typeName(t); ok {
-				outputData(pkg, name)
-				return
-			}
-			log.Fatalf("not a package-level definition (ident: %v, object: %v) and unable to follow type (type: %v)", identX, obj, t)
-			return
+			return newDefInfo("builtin", obj.Name()).withObject(obj), nil
+		}
+		t := dereferenceType(obj.Type())
+		if def, ok := typeInfo(t, pkgFiles); ok {
+			return def, nil
 		}
+		return nil, errorf(ErrUnsupported, "not a package-level definition (ident: %v, object: %v) and unable to follow type (type: %v)", identX, obj, t)
 	} else if sel, ok := info.Selections[selX]; ok {
-		recv, ok := dereferenceType(deepRecvType(sel)).(*types.Named)
+		recv, ok := dereferenceType(p.r.deepRecvType(sel)).(*types.Named)
 		if !ok || recv == nil || recv.Obj() == nil || recv.Obj().Pkg() == nil || recv.Obj().Pkg().Scope().Lookup(recv.Obj().Name()) != recv.Obj() {
-			log.Fatal("receiver is not a top-level named type")
+			return nil, errorf(ErrUnsupported, "receiver is not a top-level named type")
 		}
 
 		field, _, _ := types.LookupFieldOrMethod(sel.Recv(), true, pkg, identX.Name)
 		if field == nil {
 			// field invoked, but object is selected
-			t := dereferenceType(obj.Type())
-			if pkg, name, ok := typeName(t); ok {
-				outputData(pkg, name)
-				return
+			if def, ok := typeInfo(dereferenceType(obj.Type()), pkgFiles); ok {
+				return def, nil
 			}
-			log.Fatal("method or field not found")
+			return nil, errorf(ErrNotFound, "method or field not found")
 		}
 
-		outputData(objectString(recv.Obj()), identX.Name)
-	} else {
-		// Qualified reference (to another package's top-level
-		// definition).
-		if obj := info.Uses[selX.Sel]; obj != nil {
-			outputData(objectString(obj))
-		} else {
-			log.Fatal("no selector type")
-		}
+		return newDefInfo(recv.Obj().Pkg().Path(), recv.Obj().Name(), identX.Name).withObject(obj), nil
 	}
 
-	if *repetitions > 1 {
-		*repetitions--
-		goto repeat
+	// Qualified reference (to another package's top-level
+	// definition).
+	if obj := info.Uses[selX.Sel]; obj != nil {
+		return objectInfo(originObject(obj)), nil
 	}
+	return nil, errorf(ErrNotFound, "no selector type")
 }
 
-func parsePackage(filename string, src []byte) (files []*ast.File, err error) {
-	if src == nil {
-		src, err = ioutil.ReadFile(filename)
-		if err != nil {
+func (r *Resolver) parsePackage(ctxt *build.Context, filename string, src []byte, ov overlay) (files []*ast.File, err error) {
+	// Treat an unrecoverable parse error on the primary file
+	// (including a missing package clause, without which nothing
+	// else is parsed) as fatal, but otherwise be tolerant of errors.
+	f, err := r.parseFile(filename, src)
+	if f == nil || !f.Package.IsValid() || (r.opts.Strict && err != nil) {
+		if _, ok := err.(*Error); ok {
 			return nil, err
 		}
-	}
-
-	// Treat an unrecoverable parse error on the primary file
-	// as fatal, but otherwise be tolerant of errors.
-	f, err := parser.ParseFile(fset, filename, src, 0)
-	if f == nil || (*strict && err != nil) {
-		return nil, err
+		return nil, wrapError(ErrParse, "parsing source file", err)
 	}
 	files = append(files, f)
 
-	fileFilter := func(fi os.FileInfo) bool {
-		if !strings.HasSuffix(fi.Name(), ".go") {
-			return false
-		}
-
+	dir := filepath.Dir(filename)
+	fileFilter := func(name string) bool {
 		// We already parsed the primary file, so don't reparse it.
-		if fi.Name() == filepath.Base(filename) {
+		if name == filepath.Base(filename) {
 			return false
 		}
 
 		// Include *_test.go files only if the primary file is a test file.
 		includeTestFiles := strings.HasSuffix(filename, "_test.go")
-		return includeTestFiles || !strings.HasSuffix(fi.Name(), "_test.go")
+		return matchFile(ctxt, dir, name, includeTestFiles)
 	}
 
-	pkgs, err := parser.ParseDir(fset, filepath.Dir(filename), fileFilter, 0)
+	pkgs, err := r.parseDir(dir, fileFilter, ov)
 	if err != nil {
-		if *strict {
-			return nil, err
-		}
-		dlog.Println(err)
-	}
-	for pkgName, pkg := range pkgs {
-		if pkgName == f.Name.Name {
-			for _, f := range pkg.Files {
-				files = append(files, f)
-			}
+		if r.opts.Strict {
+			return nil, wrapError(ErrParse, "parsing package", err)
 		}
+		r.dlog.Println(err)
 	}
+	files = append(files, pkgs[f.Name.Name]...)
 	return files, nil
 }
 
 // deepRecvType gets the embedded struct's name that the method or
 // field is actually defined on, not just the original/outer recv
 // type.
-func deepRecvType(sel *types.Selection) types.Type {
+func (r *Resolver) deepRecvType(sel *types.Selection) types.Type {
 	var offset int
 	offset = 1
 	if sel.Kind() == types.MethodVal || sel.Kind() == types.MethodExpr {
@@ -339,7 +345,7 @@ func deepRecvType(sel *types.Selection) types.Type {
 		final := k == len(idx)-offset-1
 		t := getMethod(typ, i, final, sel.Kind() != types.FieldVal)
 		if t == nil {
-			dlog.Printf("failed to get method/field at index %v on recv %s", idx, typ)
+			r.dlog.Printf("failed to get method/field at index %v on recv %s", idx, typ)
 			return nil
 		}
 		typ = t.Type()
@@ -354,9 +360,16 @@ func dereferenceType(typ types.Type) types.Type {
 	return typ
 }
 
+// typeName returns the package and name of typ's declaration. An
+// instantiated generic type is named by its generic origin.
 func typeName(typ types.Type) (pkg, name string, ok bool) {
 	switch typ := typ.(type) {
 	case *types.Named:
+		typ = typ.Origin()
+		if typ.Obj().Pkg() == nil {
+			// Predeclared (error, comparable).
+			return "builtin", typ.Obj().Name(), true
+		}
 		return typ.Obj().Pkg().Path(), typ.Obj().Name(), true
 	case *types.Basic:
 		return "builtin", typ.Name(), true
@@ -364,6 +377,191 @@ func typeName(typ types.Type) (pkg, name string, ok bool) {
 	return "", "", false
 }
 
+// typeObject returns the object that declares typ, if it is a named
+// type.
+func typeObject(typ types.Type) types.Object {
+	switch typ := typ.(type) {
+	case *types.Named:
+		return typ.Origin().Obj()
+	case *types.Basic:
+		return types.Universe.Lookup(typ.Name())
+	case *types.TypeParam:
+		return typ.Obj()
+	}
+	return nil
+}
+
+// typeInfo returns the DefInfo of typ's declaration, if it is a named
+// type, a basic type or a type parameter.
+func typeInfo(typ types.Type, files []*ast.File) (*DefInfo, bool) {
+	if tp, ok := typ.(*types.TypeParam); ok {
+		return typeParamInfo(tp.Obj(), files)
+	}
+	if pkg, name, ok := typeName(typ); ok {
+		return newDefInfo(pkg, name).withObject(typeObject(typ)), true
+	}
+	return nil, false
+}
+
+// typeParamInfo returns the DefInfo of obj if it is a type parameter,
+// using the name of the generic func or type (or the receiver base
+// type, for a method) that declares it, in files, as the container.
+func typeParamInfo(obj types.Object, files []*ast.File) (*DefInfo, bool) {
+	tn, ok := obj.(*types.TypeName)
+	if !ok {
+		return nil, false
+	}
+	if _, ok := tn.Type().(*types.TypeParam); !ok {
+		return nil, false
+	}
+	in := func(n ast.Node) bool {
+		return n != nil && n.Pos() <= tn.Pos() && tn.Pos() < n.End()
+	}
+	var container string
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Type.TypeParams != nil && in(decl.Type.TypeParams) {
+					container = decl.Name.Name
+				} else if decl.Recv != nil && in(decl.Recv) && len(decl.Recv.List) == 1 {
+					if id, ok := unindexExpr(unparenStar(decl.Recv.List[0].Type)).(*ast.Ident); ok {
+						container = id.Name
+					}
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if spec, ok := spec.(*ast.TypeSpec); ok && spec.TypeParams != nil && in(spec.TypeParams) {
+						container = spec.Name.Name
+					}
+				}
+			}
+		}
+	}
+	if container == "" || tn.Pkg() == nil {
+		return nil, false
+	}
+	return newDefInfo(tn.Pkg().Path(), container, tn.Name()).withObject(tn), true
+}
+
+// isLocal reports whether obj is declared inside a function: a
+// parameter, result, receiver or local variable, constant or type, or
+// a label.
+func isLocal(obj types.Object) bool {
+	switch obj := obj.(type) {
+	case *types.Label:
+		return true
+	case *types.Var:
+		if obj.IsField() {
+			return false
+		}
+	case *types.TypeName:
+		if _, ok := obj.Type().(*types.TypeParam); ok {
+			return false
+		}
+	case *types.Const:
+	default:
+		return false
+	}
+	return obj.Pkg() != nil && obj.Parent() != nil && obj.Parent() != obj.Pkg().Scope()
+}
+
+// localInfo returns the DefInfo of a local definition, using the name
+// of the func (or, for a method, "Type.Method") or package-level
+// variable that it is declared in, in files, as the container.
+func localInfo(obj types.Object, files []*ast.File) *DefInfo {
+	in := func(n ast.Node) bool {
+		return n.Pos() <= obj.Pos() && obj.Pos() < n.End()
+	}
+	var container string
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			if !in(decl) {
+				continue
+			}
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				container = decl.Name.Name
+				if decl.Recv != nil && len(decl.Recv.List) == 1 {
+					if id, ok := unindexExpr(unparenStar(decl.Recv.List[0].Type)).(*ast.Ident); ok {
+						container = id.Name + "." + container
+					}
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					if spec, ok := spec.(*ast.ValueSpec); ok && in(spec) && len(spec.Names) > 0 {
+						container = spec.Names[0].Name
+					}
+				}
+			}
+		}
+	}
+	return newDefInfo(obj.Pkg().Path(), container, obj.Name()).withObject(obj)
+}
+
+// typeSwitchVar returns the object of the symbolic variable of the
+// type switch, if id (whose enclosing nodes are path) is that
+// variable's declaration, and nil otherwise.
+func typeSwitchVar(info *types.Info, path []ast.Node, id *ast.Ident) types.Object {
+	if len(path) < 3 {
+		return nil
+	}
+	assign, ok := path[1].(*ast.AssignStmt)
+	if !ok || len(assign.Lhs) != 1 || assign.Lhs[0] != id {
+		return nil
+	}
+	sw, ok := path[2].(*ast.TypeSwitchStmt)
+	if !ok || sw.Assign != assign {
+		return nil
+	}
+	for _, clause := range sw.Body.List {
+		if obj := info.Implicits[clause]; obj != nil {
+			return obj
+		}
+	}
+	return nil
+}
+
+// originObject returns the generic origin of obj if it is a method or
+// field of an instantiated type or an instantiated func, and obj
+// otherwise.
+func originObject(obj types.Object) types.Object {
+	switch o := obj.(type) {
+	case *types.Func:
+		return o.Origin()
+	case *types.Var:
+		return o.Origin()
+	}
+	return obj
+}
+
+// unindexExpr strips the type arguments from an instantiation
+// expression such as List[int] or Pair[K, V].
+func unindexExpr(x ast.Expr) ast.Expr {
+	switch ix := x.(type) {
+	case *ast.IndexExpr:
+		return ix.X
+	case *ast.IndexListExpr:
+		return ix.X
+	}
+	return x
+}
+
+// unparenStar strips parentheses and a pointer indirection from a
+// receiver type expression.
+func unparenStar(x ast.Expr) ast.Expr {
+	for {
+		switch e := x.(type) {
+		case *ast.ParenExpr:
+			x = e.X
+		case *ast.StarExpr:
+			x = e.X
+		default:
+			return x
+		}
+	}
+}
+
 func getMethod(typ types.Type, idx int, final bool, method bool) (obj types.Object) {
 	switch obj := typ.(type) {
 	case *types.Pointer:
@@ -394,97 +592,92 @@ func getMethod(typ types.Type, idx int, final bool, method bool) (obj types.Obje
 	return nil
 }
 
-func objectString(obj types.Object) string {
+// objectInfo returns the DefInfo for a package-level object.
+func objectInfo(obj types.Object) *DefInfo {
 	if obj.Pkg() != nil {
-		return fmt.Sprintf("%s %s", obj.Pkg().Path(), obj.Name())
+		return newDefInfo(obj.Pkg().Path(), obj.Name()).withObject(obj)
 	}
-	return obj.Name()
+	return newDefInfo("builtin", obj.Name()).withObject(obj)
 }
 
-var systemImp = importer.Default()
-
-func makeImporter() types.Importer {
-	imp := systemImp
-	if !*importsrc {
-		return imp
+// position returns the position of pos. Files loaded from export data
+// may be recorded relative to $GOROOT; these are made absolute.
+func (r *Resolver) position(pos token.Pos) token.Position {
+	p := r.fset.Position(pos)
+	if rest := strings.TrimPrefix(p.Filename, "$GOROOT"); rest != p.Filename {
+		p.Filename = filepath.Join(build.Default.GOROOT, rest)
 	}
-
-	if imp, ok := imp.(types.ImporterFrom); ok {
-		return &sourceImporterFrom{
-			ImporterFrom: imp,
-			cached:       map[importerPkgKey]*types.Package{},
-		}
-	}
-	return imp
-}
-
-type importerPkgKey struct{ path, srcDir string }
-
-type sourceImporterFrom struct {
-	types.ImporterFrom
-
-	cached map[importerPkgKey]*types.Package
-}
-
-func (s *sourceImporterFrom) Import(path string) (*types.Package, error) {
-	return s.ImportFrom(path, "" /* no vendoring */, 0)
+	return p
 }
 
-var _ (types.ImporterFrom) = (*sourceImporterFrom)(nil)
-
-func (s *sourceImporterFrom) ImportFrom(path, srcDir string, mode types.ImportMode) (*types.Package, error) {
-	pkg, err := s.ImporterFrom.ImportFrom(path, srcDir, mode)
-	if pkg != nil {
-		return pkg, err
+// setPosition sets the position fields of def from the position of
+// its object. Objects imported from export data only have accurate
+// line numbers, so the identifier is located in the source file to
+// get its column and offsets.
+func (p *Package) setPosition(def *DefInfo) {
+	pos := p.r.position(def.obj.Pos())
+	if pos.Filename == "" {
+		return
 	}
+	def.Filename = absPath(pos.Filename)
+	def.Line, def.Column = pos.Line, pos.Column
 
-	key := importerPkgKey{path, srcDir}
-	if pkg := s.cached[key]; pkg != nil && pkg.Complete() {
-		return pkg, nil
+	src, err := p.source(def.Filename)
+	if err != nil {
+		p.r.dlog.Println("reading definition source:", err)
+		return
 	}
 
-	if *debug {
-		t0 := time.Now()
-		defer func() {
-			dlog.Printf("source import of %s took %s", path, time.Since(t0))
-		}()
+	name := def.obj.Name()
+	offset := pos.Offset
+	if offset < 0 || offset+len(name) > len(src) || string(src[offset:offset+len(name)]) != name {
+		var ok bool
+		if offset, ok = findIdentOnLine(src, pos.Line, name); !ok {
+			p.r.dlog.Printf("%s:%d: definition of %s not found in source", def.Filename, pos.Line, name)
+			return
+		}
 	}
+	def.Column = offset - lineOffset(src, pos.Line) + 1
+	def.Offset = offset + 1
+	def.EndOffset = def.Offset + len(name)
+}
 
-	// Otherwise, parse from source.
-	pkgs, err := parser.ParseDir(fset, srcDir, func(fi os.FileInfo) bool {
-		return strings.HasSuffix(fi.Name(), ".go") && !strings.HasSuffix(fi.Name(), "_test.go")
-	}, 0)
-	if err != nil {
-		return nil, err
-	}
-	var astPkg *ast.Package
-	for pkgName, pkg := range pkgs {
-		if pkgName != "main" && !strings.HasSuffix(pkgName, "_test") {
-			astPkg = pkg
-			break
+// lineOffset returns the byte offset in src of the start of the
+// 1-based line.
+func lineOffset(src []byte, line int) int {
+	offset := 0
+	for i := 1; i < line; i++ {
+		nl := bytes.IndexByte(src[offset:], '\n')
+		if nl == -1 {
+			return len(src)
 		}
+		offset += nl + 1
 	}
-	if astPkg == nil {
-		return nil, fmt.Errorf("ImportFrom: no suitable package found (import path %q, dir %q)", path, srcDir)
-	}
+	return offset
+}
 
-	pkgFiles := make([]*ast.File, 0, len(astPkg.Files))
-	for _, f := range astPkg.Files {
-		pkgFiles = append(pkgFiles, f)
+// findIdentOnLine returns the byte offset of the first identifier
+// token named name on the 1-based line of src.
+func findIdentOnLine(src []byte, line int, name string) (int, bool) {
+	start := lineOffset(src, line)
+	end := len(src)
+	if nl := bytes.IndexByte(src[start:], '\n'); nl != -1 {
+		end = start + nl
 	}
 
-	conf := types.Config{
-		Importer:                 systemImp,
-		FakeImportC:              true,
-		IgnoreFuncBodies:         true,
-		DisableUnusedImportCheck: true,
-		Error: func(error) {},
-	}
-	pkg, err = conf.Check(path, fset, pkgFiles, nil)
-	if pkg != nil {
-		s.cached[key] = pkg
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, end-start)
+	s.Init(file, src[start:end], nil, 0)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return 0, false
+		}
+		if tok == token.IDENT && lit == name {
+			return start + file.Offset(pos), true
+		}
 	}
-	return pkg, err
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////
@@ -757,6 +950,9 @@ func childrenOf(n ast.Node) []ast.Node {
 			children = append(children, n.Recv)
 		}
 		children = append(children, n.Name)
+		if n.Type.TypeParams != nil {
+			children = append(children, n.Type.TypeParams)
+		}
 		if n.Type.Params != nil {
 			children = append(children, n.Type.Params)
 		}
@@ -809,6 +1005,11 @@ func childrenOf(n ast.Node) []ast.Node {
 			tok(n.Lbrack, len("{")),
 			tok(n.Rbrack, len("}")))
 
+	case *ast.IndexListExpr:
+		children = append(children,
+			tok(n.Lbrack, len("[")),
+			tok(n.Rbrack, len("]")))
+
 	case *ast.InterfaceType:
 		children = append(children,
 			tok(n.Interface, len("interface")))
@@ -1,18 +1,18 @@
-package main
+package godefinfo
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"go/build"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
-	"runtime"
-	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -24,13 +24,13 @@ func init() {
 		log.Fatal(err)
 	}
 	build.Default.GOPATH = filepath.Join(dir, "testdata")
-	minimalEnv = []string{"GOPATH=" + build.Default.GOPATH, "GOROOT=" + runtime.GOROOT()}
 }
 
-var minimalEnv []string
+// testResolver is shared by tests so that imported packages are only
+// loaded once.
+var testResolver = NewResolver(Options{Strict: true, ImportSrc: true})
 
-func TestSingleFile(t *testing.T) {
-	const src = `package p
+const singleFileSrc = `package p
 
 import "net/http"
 
@@ -89,19 +89,32 @@ func init() {
 
 	x, err := http.Get("http://example.com")
 	x.Body // net/http Response Body
-	x //x: net/http Response
+	x //x: p init x
 
 	w := http.ResponseWriter(nil)
 	w.Header().Set // net/http Header Set
-	w //w: net/http ResponseWriter
+	w //w: p init w
 
-	y := 3 //y: builtin int
-	y // builtin int
+	y := 3 //y: p init y
+	y // p init y
 
-	z := T{} //z: p T
-	z // p T
-	z.M0 //z: p T
+	z := T{} //z: p init z
+	z // p init z
+	z.M0 //z: p init z
 	T{F0: 1} //F0: p T F0
+
+	var li List[int] //List: p List
+	li.Push // p List Push
+	li //li: p init li
+	(&List[string]{}).Len // p List Len
+	List[int]{head: nil} //head: p List head
+	Pair[string, int]{}.Key // p Pair Key
+	Pair[string, int]{Val: 1} //Val: p Pair Val
+	Pair[string, List[int]] //List: p List
+	Sum[int] // p Sum
+	Sum(1, 2) //Sum: p Sum
+	(&Wrap{}).Push // p List Push
+	Getter[int](nil).Get // p Getter Get
 }
 
 func F() {} //F: p F
@@ -152,9 +165,59 @@ type L interface {
 
 var M = 1 //M: p M
 
+type List[E any] struct { //E: p List E
+	head *E //E: p List E
+	next *List[E] //next: p List next
+}
+
+func (l *List[E]) Push(v E) {} //v: p List.Push v
+
+func (l *List[E]) Len() int { return 0 } //Len: p List Len
+
+type Pair[K comparable, V any] struct { //K: p Pair K
+	Key K
+	Val V
+}
+
+type Number interface { //Number: p Number
+	~int | ~float64 //float64: builtin float64
+}
+
+func Sum[N Number](xs ...N) N { //Number: p Number
+	var s N //N: p Sum N
+	s //s: p Sum s
+	return s
+}
+
+type Wrap struct {
+	List[int] //List: p List
+}
+
+type Getter[T any] interface {
+	Get() T //T: p Getter T
+}
+
+func Loop(a int) (r string) { //a: p Loop a
+	for i, v := range []int{a} { //v: p Loop v
+		_ = i + v //i: p Loop i
+	}
+	switch u := interface{}(a).(type) { //u: p Loop u
+	case int:
+		_ = u //u: p Loop u
+	}
+	r = "" //r: p Loop r
+L: //L: p Loop L
+	for {
+		break L //L: p Loop L
+	}
+	return
+}
+
 const N = 2 //N: p N
 `
 
+func TestSingleFile(t *testing.T) {
+	const src = singleFileSrc
 	const filename = "/tmp/godef_testdata.go"
 	if *writeGoFile {
 		if err := ioutil.WriteFile(filename, []byte(src), 0600); err != nil {
@@ -163,17 +226,55 @@ const N = 2 //N: p N
 		t.Log("wrote test file to", filename)
 	}
 
-	testFile(t, filename, src)
+	testFile(t, testResolver, filename, src)
 }
 
-func TestGOPATH(t *testing.T) {
-	cmd := exec.Command("go", "install", "mypkg/subpkg")
-	cmd.Env = minimalEnv
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		t.Fatalf("go build mypkg/subpkg failed: %s (output follows)\n\n%s", err, out)
+// TestLocalTypes tests that locals resolve to their types with the
+// LocalTypes option.
+func TestLocalTypes(t *testing.T) {
+	const src = `package p
+
+import "net/http"
+
+func init() {
+	x, _ := http.Get("http://example.com")
+	x //x: net/http Response
+	y := 3 //y: builtin int
+	y // builtin int
+	z := T{} //z: p T
+	z.M //z: p T
+}
+
+type T struct{}
+
+func (t *T) M(v List[int]) {} //v: p List
+
+type List[E any] []E
+
+func Sum[N any](xs ...N) N {
+	var s N
+	return s //s: p Sum N
+}
+`
+	r := NewResolver(Options{Strict: true, ImportSrc: true, LocalTypes: true})
+	testFile(t, r, "/tmp/godef_localtypes.go", src)
+}
+
+func TestConcurrentResolve(t *testing.T) {
+	r := NewResolver(Options{Strict: true, ImportSrc: true})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			testFile(t, r, fmt.Sprintf("/tmp/godef_concurrent%d.go", i), singleFileSrc)
+		}(i)
 	}
+	wg.Wait()
+}
 
+func TestGOPATH(t *testing.T) {
+	t.Setenv("GO111MODULE", "off")
 	filenames := []string{
 		"mypkg/a.go",
 		"mypkg/b.go",
@@ -188,12 +289,12 @@ func TestGOPATH(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		testFile(t, filename, string(src))
+		testFile(t, testResolver, filename, string(src))
 	}
 }
 
-func testFile(t *testing.T, filename, src string) {
-	pat := regexp.MustCompile(`\s*(?P<ref>.+)\s*//(?:(?P<tok>\w+):)? (?P<pkg>[\w/.-]+)(?: (?P<name1>\w+)(?: (?P<name2>\w+))?)?`)
+func testFile(t *testing.T, r *Resolver, filename, src string) {
+	pat := regexp.MustCompile(`\s*(?P<ref>.+)\s*//(?:(?P<tok>\w+):)? (?P<pkg>[\w/.-]+)(?: (?P<name1>[\w.]+)(?: (?P<name2>\w+))?)?`)
 	matches := pat.FindAllStringSubmatchIndex(src, -1)
 	if numTests := strings.Count(src, " //"); len(matches) != numTests {
 		t.Fatalf("%s: source has %d tests (lines with ' // '), but %d matches found (regexp probably needs to be updated to include new styles of test specifications)", filename, numTests, len(matches))
@@ -235,7 +336,7 @@ func testFile(t *testing.T, filename, src string) {
 		label := fmt.Sprintf("%s: ref %q at offset %d", filename, ref, m[2])
 
 		var out string
-		pkg, name1, name2, err := check(filename, src, m[2], &out)
+		pkg, name1, name2, err := check(r, filename, src, m[2], &out)
 		if err != nil {
 			t.Errorf("%s: error: %s", label, err)
 			continue
@@ -255,17 +356,17 @@ func testFile(t *testing.T, filename, src string) {
 	}
 }
 
-func check(filename, src string, offset int, saveOutput *string) (pkg, name1, name2 string, err error) {
-	cmd := exec.Command("godefinfo", "-i", "-o", strconv.Itoa(offset), "-f", filename, "-strict", "-importsrc")
-	cmd.Env = minimalEnv
-	cmd.Stdin = ioutil.NopCloser(strings.NewReader(src))
-	outB, err := cmd.CombinedOutput()
+func check(r *Resolver, filename, src string, offset int, saveOutput *string) (pkg, name1, name2 string, err error) {
+	def, err := r.Resolve(context.Background(), Query{
+		Filename: filename,
+		Src:      []byte(src),
+		Offset:   offset,
+	})
 	if err != nil {
-		err = fmt.Errorf("%s (output was: %q)", err, outB)
 		return
 	}
 
-	out := strings.TrimSuffix(string(outB), "\n")
+	out := def.String()
 	if saveOutput != nil {
 		*saveOutput = out
 	}
@@ -284,3 +385,170 @@ func check(filename, src string, offset int, saveOutput *string) (pkg, name1, na
 	}
 	return
 }
+
+func TestErrors(t *testing.T) {
+	const src = `package p
+
+func init() {
+	_ = "x" + y
+	X.M()
+}
+
+var X interface{ M() }
+`
+	tests := []struct {
+		offset int
+		want   ErrorCode
+	}{
+		{strings.Index(src, `"x"`) + 1, ErrNoIdentifier},
+		{strings.Index(src, "y") + 1, ErrNoTypeInfo},
+		{strings.Index(src, "X.M") + 3, ErrUnsupported}, // method of an anonymous interface
+		{strings.Index(src, "{ M") + 3, ErrUnsupported}, // and its declaration
+	}
+	for _, test := range tests {
+		_, err := Resolve(context.Background(), Query{Filename: "/tmp/godef_errors.go", Src: []byte(src), Offset: test.offset})
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("offset %d: got error %v, want *Error", test.offset, err)
+			continue
+		}
+		if e.Code != test.want {
+			t.Errorf("offset %d: got code %q, want %q", test.offset, e.Code, test.want)
+		}
+	}
+
+	strict := NewResolver(Options{Strict: true, ImportSrc: true})
+	_, err := strict.Resolve(context.Background(), Query{Filename: "/tmp/godef_errors.go", Src: []byte(src), Offset: 1})
+	if e, ok := err.(*Error); !ok || e.Code != ErrTypeCheck {
+		t.Errorf("strict: got error %v, want code %q", err, ErrTypeCheck)
+	}
+
+	// Nothing is parsed from a file without a package clause.
+	for _, src := range []string{"", "x := 1\n"} {
+		q := Query{Filename: "/tmp/godef_errors.go", Src: []byte(src), Offset: 1}
+		if _, err := Resolve(context.Background(), q); !isCode(err, ErrParse) {
+			t.Errorf("%q: got error %v, want code %q", src, err, ErrParse)
+		}
+	}
+
+	// An empty file beside the package's files is ignored, unless it
+	// is the primary file.
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"p.go":     "package p\n\nvar X int\n",
+		"empty.go": "",
+	})
+	r := NewResolver(Options{ImportSrc: true})
+	if _, err := r.Resolve(context.Background(), Query{Filename: filepath.Join(dir, "p.go"), Offset: 16}); err != nil {
+		t.Errorf("p.go: %v", err)
+	}
+	if _, err := r.Resolve(context.Background(), Query{Filename: filepath.Join(dir, "empty.go"), Offset: 1}); !isCode(err, ErrParse) {
+		t.Errorf("empty.go: got error %v, want code %q", err, ErrParse)
+	}
+	if tags, err := r.Tags(context.Background(), dir); err != nil || len(tags) != 1 {
+		t.Errorf("tags: got %d tags and error %v, want 1 tag", len(tags), err)
+	}
+}
+
+// isCode reports whether err is an *Error with the given code.
+func isCode(err error, code ErrorCode) bool {
+	e, ok := err.(*Error)
+	return ok && e.Code == code
+}
+
+func TestModules(t *testing.T) {
+	modcache, err := filepath.Abs("testdata/modules/modcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOMODCACHE", modcache)
+
+	filenames := []string{
+		"app/app.go",
+		"vendored/vendored.go",
+	}
+	for _, filename := range filenames {
+		filename, err := filepath.Abs(filepath.Join("testdata/modules", filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testFile(t, NewResolver(Options{Strict: true, ImportSrc: true}), filename, string(src))
+	}
+}
+
+func TestPositions(t *testing.T) {
+	modcache, err := filepath.Abs("testdata/modules/modcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOMODCACHE", modcache)
+	app, err := filepath.Abs("testdata/modules/app/app.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	appSrc, err := ioutil.ReadFile(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const src = "package p\n\nimport \"net/http\"\n\nvar _ = http.Response{}.Body\n\ntype T struct{ F int }\n\nvar _ = T{}.F\n"
+
+	tests := []struct {
+		opts     Options
+		filename string
+		src      []byte
+		at       string // text that ends with the identifier
+		ident    string
+		wantFile string
+		wantLine int
+	}{
+		{Options{ImportSrc: true}, "/tmp/p/p.go", []byte(src), "T{}.F", "F", "/tmp/p/p.go", 7},
+		{Options{ImportSrc: true}, "/tmp/p/p.go", []byte(src), "}.Body", "Body", filepath.Join(build.Default.GOROOT, "src/net/http/response.go"), 0},
+		{Options{}, "/tmp/p/p.go", []byte(src), "}.Body", "Body", filepath.Join(build.Default.GOROOT, "src/net/http/response.go"), 0},
+		{Options{ImportSrc: true}, app, appSrc, "x.X", "X", filepath.Join(modcache, "example.com/dep@v1.2.3/x/x.go"), 0},
+	}
+	for _, test := range tests {
+		t.Setenv("GO111MODULE", "off")
+		if test.filename == app {
+			t.Setenv("GO111MODULE", "on")
+		}
+		def, err := NewResolver(test.opts).Resolve(context.Background(), Query{
+			Filename: test.filename,
+			Src:      test.src,
+			Offset:   strings.Index(string(test.src), test.at) + len(test.at) - len(test.ident) + 1,
+		})
+		if err != nil {
+			t.Errorf("%s: %s", test.ident, err)
+			continue
+		}
+		if def.Filename != test.wantFile {
+			t.Errorf("%s: got filename %q, want %q", test.ident, def.Filename, test.wantFile)
+			continue
+		}
+		if test.wantLine != 0 && def.Line != test.wantLine {
+			t.Errorf("%s: got line %d, want %d", test.ident, def.Line, test.wantLine)
+		}
+
+		// The offsets, line and column must all locate the identifier.
+		defSrc := test.src
+		if def.Filename != test.filename {
+			if defSrc, err = ioutil.ReadFile(def.Filename); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if def.Offset == 0 || def.EndOffset > len(defSrc)+1 {
+			t.Errorf("%s: bad offsets %d-%d", test.ident, def.Offset, def.EndOffset)
+			continue
+		}
+		if got := string(defSrc[def.Offset-1 : def.EndOffset-1]); got != test.ident {
+			t.Errorf("%s: offsets %d-%d locate %q", test.ident, def.Offset, def.EndOffset, got)
+		}
+		if got := lineOffset(defSrc, def.Line) + def.Column; got != def.Offset {
+			t.Errorf("%s: line %d, column %d is at offset %d, want %d", test.ident, def.Line, def.Column, got, def.Offset)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package godefinfo
+
+import (
+	"go/ast"
+)
+
+// doc returns the doc comment of the declaration of def, whose
+// position must be known. The declaring file is parsed if it wasn't
+// already (eg, because its package was imported from export data).
+func (p *Package) doc(def *DefInfo) string {
+	f, err := p.r.parseFile(def.Filename, p.overlaid(def.Filename))
+	if f == nil || !f.Package.IsValid() {
+		p.r.dlog.Println("parsing definition source:", err)
+		return ""
+	}
+	pos := p.r.fset.File(f.Pos()).Pos(def.Offset - 1)
+	path, _ := pathEnclosingInterval(f, pos, pos)
+	for i, n := range path {
+		switch n := n.(type) {
+		case *ast.Field:
+			if n.Doc == nil {
+				return n.Comment.Text()
+			}
+			return n.Doc.Text()
+		case *ast.ValueSpec:
+			if n.Doc == nil {
+				return genDeclDoc(path[i+1:])
+			}
+			return n.Doc.Text()
+		case *ast.TypeSpec:
+			if n.Doc == nil {
+				return genDeclDoc(path[i+1:])
+			}
+			return n.Doc.Text()
+		case *ast.FuncDecl:
+			return n.Doc.Text()
+		case *ast.FuncLit, *ast.BlockStmt:
+			// Locals have no doc comments.
+			return ""
+		}
+	}
+	return ""
+}
+
+// genDeclDoc returns the doc comment of the GenDecl at the start of
+// path if it declares a single spec without parentheses, like
+// go/doc does.
+func genDeclDoc(path []ast.Node) string {
+	if len(path) > 0 {
+		if decl, ok := path[0].(*ast.GenDecl); ok && !decl.Lparen.IsValid() {
+			return decl.Doc.Text()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,71 @@
+package godefinfo
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDocs(t *testing.T) {
+	t.Setenv("GO111MODULE", "off")
+	const src = `package p
+
+import "net/http"
+
+// T is a type.
+type T struct {
+	// F is a field.
+	F int
+	G int // G is a field too.
+}
+
+// C is a constant.
+const C = 1
+
+const (
+	// D is in a group.
+	D = 2
+)
+
+var _ = T{}.F
+var _ = T{}.G
+var _ = C + D
+var _ = http.DefaultClient.Do
+var _ = http.Response{}.Body
+`
+	filename := filepath.Join(t.TempDir(), "p.go")
+	tests := []struct {
+		at      string // text that ends with the identifier
+		wantDoc string // prefix of the doc comment
+		wantSig string // prefix of the signature
+	}{
+		{"_ = T", "T is a type.\n", "type T struct{F int; G int}"},
+		{"T{}.F", "F is a field.\n", "field F int"},
+		{"T{}.G", "G is a field too.\n", "field G int"},
+		{"_ = C", "C is a constant.\n", "const C untyped int = 1"},
+		{"C + D", "D is in a group.\n", "const D untyped int = 2"},
+		{"DefaultClient.Do", "Do sends an HTTP request", "func (c *Client) Do(req *Request) (*Response, error)"},
+		{"{}.Body", "Body represents the response body.", "field Body io.ReadCloser"},
+	}
+	for _, importSrc := range []bool{true, false} {
+		r := NewResolver(Options{ImportSrc: importSrc, Docs: true})
+		for _, test := range tests {
+			def, err := r.Resolve(context.Background(), Query{
+				Filename: filename,
+				Src:      []byte(src),
+				Offset:   strings.Index(src, test.at) + len(test.at),
+			})
+			if err != nil {
+				t.Errorf("%s: %s", test.at, err)
+				continue
+			}
+			if !strings.HasPrefix(def.Doc, test.wantDoc) {
+				t.Errorf("%s (importsrc=%v): got doc %q, want it to start with %q", test.at, importSrc, def.Doc, test.wantDoc)
+			}
+			if !strings.HasPrefix(def.Signature, test.wantSig) {
+				t.Errorf("%s (importsrc=%v): got signature %q, want it to start with %q", test.at, importSrc, def.Signature, test.wantSig)
+			}
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package godefinfo
+
+import (
+	"context"
+	"go/types"
+	"path/filepath"
+	"sort"
+)
+
+// Implementations finds the implementations of the interface type or
+// interface method whose identifier is at the 1-based byte offset in
+// the named file (see Resolve): the named types whose method sets (or
+// whose pointers' method sets) satisfy the interface, or the concrete
+// methods of those types, in the packages in root (see References).
+// A method that a type gets by embedding is reported at its
+// declaration in the embedded type.
+//
+// In reverse, for a concrete named type it finds the interfaces in the
+// packages in root that the type satisfies, and for a concrete method
+// it finds the methods of those interfaces that it implements.
+//
+// Packages are loaded without their tests, and uninstantiated generic
+// types are not considered. Definitions are returned sorted by
+// filename and offset.
+func (p *Package) Implementations(ctx context.Context, filename string, offset int, root string) ([]*DefInfo, error) {
+	file := p.file(filename)
+	if file == nil {
+		return nil, errorf(ErrNoIdentifier, "%s is not in package %s", filename, p.pkg.Path())
+	}
+	def, err := p.resolveFile(file, offset)
+	if err != nil {
+		return nil, err
+	}
+	if def.obj == nil || def.obj.Pkg() == nil {
+		return nil, errorf(ErrUnsupported, "finding implementations of %s is not supported", def)
+	}
+
+	ov := p.searchOverlay()
+	ctxt := p.r.buildContext(ov)
+	dirs, err := p.searchDirs(ctxt, ov, root)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the packages through the importer, so that they all share
+	// the same imported packages and their types can be compared.
+	imp := p.r.importer(ctxt, ov, filepath.Dir(absPath(p.q.Filename))).(types.ImporterFrom)
+	var pkgs []*types.Package
+	seenPkg := map[*types.Package]bool{}
+	addPkg := func(pkg *types.Package) {
+		if !seenPkg[pkg] {
+			seenPkg[pkg] = true
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		path := importPathForDir(dir)
+		if path == "" {
+			p.r.dlog.Printf("%s: unknown import path", dir)
+			continue
+		}
+		pkg, err := imp.ImportFrom(path, dir, 0)
+		if pkg == nil {
+			p.r.dlog.Println(err)
+			continue
+		}
+		addPkg(pkg)
+	}
+
+	// The definition's package was checked separately; use its
+	// imported counterpart.
+	target := def.obj
+	if tf := p.r.fset.File(file.Pos()); target.Pkg() == p.pkg && tf != nil {
+		dir := filepath.Dir(absPath(tf.Name()))
+		if pkg, _ := imp.ImportFrom(p.pkg.Path(), dir, 0); pkg != nil {
+			k := newKeyer(p.r)
+			if target = lookupObject(pkg, k, k.key(def.obj)); target == nil {
+				return nil, errorf(ErrUnsupported, "%s is not a package-level type or a method", def)
+			}
+		}
+	}
+	addPkg(target.Pkg())
+
+	var named []*types.Named
+	for _, pkg := range pkgs {
+		scope := pkg.Scope()
+		for _, name := range scope.Names() {
+			if tn, ok := scope.Lookup(name).(*types.TypeName); ok && !tn.IsAlias() {
+				if t, ok := tn.Type().(*types.Named); ok && t.TypeParams().Len() == 0 {
+					named = append(named, t)
+				}
+			}
+		}
+	}
+
+	var objs []types.Object
+	switch target := target.(type) {
+	case *types.TypeName:
+		t, ok := target.Type().(*types.Named)
+		if !ok || t.TypeParams().Len() > 0 {
+			return nil, errorf(ErrUnsupported, "%s is not a named non-generic type", def)
+		}
+		if iface, ok := t.Underlying().(*types.Interface); ok {
+			for _, impl := range implementers(named, iface) {
+				objs = append(objs, impl.Obj())
+			}
+		} else {
+			for _, iface := range implemented(named, t) {
+				objs = append(objs, iface.Obj())
+			}
+		}
+
+	case *types.Func:
+		recv := target.Type().(*types.Signature).Recv()
+		if recv == nil {
+			return nil, errorf(ErrUnsupported, "%s is not a method", def)
+		}
+		if iface, ok := recv.Type().Underlying().(*types.Interface); ok {
+			for _, impl := range implementers(named, iface) {
+				if m, _, _ := types.LookupFieldOrMethod(impl, true, target.Pkg(), target.Name()); m != nil {
+					objs = append(objs, m)
+				}
+			}
+		} else if t, ok := dereferenceType(recv.Type()).(*types.Named); ok && t.TypeParams().Len() == 0 {
+			for _, iface := range implemented(named, t) {
+				if m, _, _ := types.LookupFieldOrMethod(iface, false, target.Pkg(), target.Name()); m != nil {
+					objs = append(objs, m)
+				}
+			}
+		}
+
+	default:
+		return nil, errorf(ErrUnsupported, "%s is not a type or a method", def)
+	}
+
+	var defs []*DefInfo
+	seen := map[types.Object]bool{}
+	for _, obj := range objs {
+		if seen[obj] {
+			continue
+		}
+		seen[obj] = true
+		def := methodOrTypeInfo(obj)
+		p.complete(def)
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		if defs[i].Filename != defs[j].Filename {
+			return defs[i].Filename < defs[j].Filename
+		}
+		return defs[i].Offset < defs[j].Offset
+	})
+	return defs, nil
+}
+
+// implementers returns the concrete types among named that (or whose
+// pointers) satisfy iface.
+func implementers(named []*types.Named, iface *types.Interface) []*types.Named {
+	var impls []*types.Named
+	for _, t := range named {
+		if types.IsInterface(t) {
+			continue
+		}
+		if types.Implements(t, iface) || types.Implements(types.NewPointer(t), iface) {
+			impls = append(impls, t)
+		}
+	}
+	return impls
+}
+
+// implemented returns the interfaces among named, other than empty
+// ones, that t (or a pointer to it) satisfies.
+func implemented(named []*types.Named, t *types.Named) []*types.Named {
+	var ifaces []*types.Named
+	for _, it := range named {
+		iface, ok := it.Underlying().(*types.Interface)
+		if !ok || iface.NumMethods() == 0 {
+			continue
+		}
+		if types.Implements(t, iface) || types.Implements(types.NewPointer(t), iface) {
+			ifaces = append(ifaces, it)
+		}
+	}
+	return ifaces
+}
+
+// lookupObject returns the package-level type or the method in pkg
+// whose key is key, or nil if there is none.
+func lookupObject(pkg *types.Package, k *keyer, key objKey) types.Object {
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if k.key(obj).matches(key) {
+			return obj
+		}
+		t, ok := obj.Type().(*types.Named)
+		if _, isType := obj.(*types.TypeName); !isType || !ok {
+			continue
+		}
+		for i := 0; i < t.NumMethods(); i++ {
+			if m := t.Method(i); k.key(m).matches(key) {
+				return m
+			}
+		}
+		if iface, ok := t.Underlying().(*types.Interface); ok {
+			for i := 0; i < iface.NumExplicitMethods(); i++ {
+				if m := iface.ExplicitMethod(i); k.key(m).matches(key) {
+					return m
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// methodOrTypeInfo returns the DefInfo of a package-level type or a
+// method (of a named type or interface).
+func methodOrTypeInfo(obj types.Object) *DefInfo {
+	if sig, ok := obj.Type().(*types.Signature); ok && sig.Recv() != nil {
+		if recv, ok := dereferenceType(sig.Recv().Type()).(*types.Named); ok {
+			return newDefInfo(obj.Pkg().Path(), recv.Origin().Obj().Name(), obj.Name()).withObject(obj)
+		}
+	}
+	return objectInfo(obj)
+}
@@ -0,0 +1,88 @@
+package godefinfo
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImplementations(t *testing.T) {
+	const shapesSrc = `package shapes
+
+type Shape interface {
+	Area() float64
+}
+
+type Solid interface {
+	Shape
+	Volume() float64
+}
+
+type Square struct{ Side float64 }
+
+func (s Square) Area() float64 { return s.Side * s.Side }
+
+type Cube struct{ Square }
+
+func (c *Cube) Volume() float64 { return c.Area() * c.Side }
+`
+	gopath := t.TempDir()
+	withGOPATH(t, gopath)
+	t.Setenv("GO111MODULE", "off")
+	src := filepath.Join(gopath, "src")
+	writeFiles(t, src, map[string]string{
+		"shapes/shapes.go": shapesSrc,
+		"circle/circle.go": `package circle
+
+type Circle struct{ R float64 }
+
+func (c Circle) Area() float64 { return 3 * c.R * c.R }
+
+type Namer interface{ Name() string }
+`,
+		"other/other.go": "package other\n\ntype Areaer interface{ Area() float64 }\n",
+	})
+	filename := filepath.Join(src, "shapes", "shapes.go")
+	p, err := NewResolver(Options{Strict: true, ImportSrc: true}).Check(context.Background(), Query{Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at   string // the identifier ends the first occurrence of at
+		want []string
+	}{
+		{"type Shape", []string{"circle Circle", "shapes Square", "shapes Cube"}},
+		{"type Solid", []string{"shapes Cube"}},
+		{"\tArea", []string{"circle Circle Area", "shapes Square Area"}},
+		{"\tVolume", []string{"shapes Cube Volume"}},
+		{"type Square", []string{"other Areaer", "shapes Shape"}},
+		{"type Cube", []string{"other Areaer", "shapes Shape", "shapes Solid"}},
+		{"func (s Square) Area", []string{"other Areaer Area", "shapes Shape Area"}},
+		{"func (c *Cube) Volume", []string{"shapes Solid Volume"}},
+	}
+	for _, test := range tests {
+		offset := strings.Index(shapesSrc, test.at) + len(test.at)
+		defs, err := p.Implementations(context.Background(), "", offset, "")
+		if err != nil {
+			t.Errorf("%q: %s", test.at, err)
+			continue
+		}
+		var got []string
+		for _, def := range defs {
+			got = append(got, def.String())
+			if def.Filename == "" || def.Offset == 0 {
+				t.Errorf("%q: %s has no position", test.at, def)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%q: got %q, want %q", test.at, got, test.want)
+		}
+	}
+
+	if _, err := p.Implementations(context.Background(), "", strings.Index(shapesSrc, "Side float64")+1, ""); err == nil {
+		t.Error("implementations of a field: got no error")
+	}
+}
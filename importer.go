@@ -0,0 +1,462 @@
+package godefinfo
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// importer returns the importer to use for type checking a query's
+// package in srcDir, which selects files using ctxt and reads
+// overlaid files from ov.
+func (r *Resolver) importer(ctxt *build.Context, ov overlay, srcDir string) types.Importer {
+	if !r.opts.ImportSrc {
+		return r.systemImp
+	}
+	modRoot, mod := findModule(srcDir)
+	return &sourceImporterFrom{
+		ImporterFrom: r.systemImp,
+		r:            r,
+		ctxt:         ctxt,
+		overlay:      ov,
+		srcDir:       srcDir,
+		modRoot:      modRoot,
+		mod:          mod,
+	}
+}
+
+// useExportData reports whether export data describes packages as r's
+// options select them. Export data built by -gobuild honors the
+// options; otherwise it is compiled for the host's configuration.
+func (r *Resolver) useExportData() bool {
+	return r.opts.GoBuild || (r.opts.GOOS == "" || r.opts.GOOS == build.Default.GOOS) &&
+		(r.opts.GOARCH == "" || r.opts.GOARCH == build.Default.GOARCH) &&
+		len(r.opts.BuildTags) == 0
+}
+
+// lockedImporter serializes calls to an importer that is not safe for
+// concurrent use (such as the gc export data importer).
+type lockedImporter struct {
+	mu  sync.Mutex
+	imp types.ImporterFrom
+}
+
+func (l *lockedImporter) Import(path string) (*types.Package, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.imp.Import(path)
+}
+
+func (l *lockedImporter) ImportFrom(path, srcDir string, mode types.ImportMode) (*types.Package, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.imp.ImportFrom(path, srcDir, mode)
+}
+
+// importerPkgKey identifies a source-imported package. The same import
+// path can refer to different packages (eg, with vendoring), so the
+// package's directory is part of the key.
+type importerPkgKey struct{ path, dir string }
+
+// importCache holds the packages imported from source by a Resolver,
+// along with what is needed to tell when they become stale.
+type importCache struct {
+	mu      sync.Mutex
+	entries map[importerPkgKey]*importEntry
+}
+
+type importEntry struct {
+	pkg *types.Package
+
+	dir   string      // the package's source directory
+	files []fileStamp // the package's source files when it was imported
+
+	// deps are the keys of the source-imported packages that pkg
+	// imports.
+	deps []importerPkgKey
+}
+
+// fileStamp identifies a version of a file: by modification time and
+// size for files on disk, and by a hash of the contents for overlaid
+// files.
+type fileStamp struct {
+	name    string
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+func newImportCache() *importCache {
+	return &importCache{entries: map[importerPkgKey]*importEntry{}}
+}
+
+func (c *importCache) get(key importerPkgKey) *importEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key]
+}
+
+// add stores e under key, unless another goroutine stored a package
+// first, in which case that one is returned so that all queries agree
+// on the identity of the package.
+func (c *importCache) add(key importerPkgKey, e *importEntry) *types.Package {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing := c.entries[key]; existing != nil && existing.pkg.Complete() {
+		return existing.pkg
+	}
+	c.entries[key] = e
+	return e.pkg
+}
+
+// invalidate drops the package for key and every package that
+// (transitively) imports it.
+func (c *importCache) invalidate(key importerPkgKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidateLocked(key)
+}
+
+func (c *importCache) invalidateLocked(key importerPkgKey) {
+	if _, ok := c.entries[key]; !ok {
+		return
+	}
+	delete(c.entries, key)
+	for k, e := range c.entries {
+		for _, dep := range e.deps {
+			if dep == key {
+				c.invalidateLocked(k)
+				break
+			}
+		}
+	}
+}
+
+// stampDir returns the stamps of the package source files in dir (as
+// selected by ctxt). Overlaid files are identified by a hash of their
+// contents.
+func stampDir(ctxt *build.Context, dir string, ov overlay) ([]fileStamp, error) {
+	names, err := listDir(dir, ov)
+	if err != nil {
+		return nil, err
+	}
+	var stamps []fileStamp
+	for _, name := range names {
+		if !matchFile(ctxt, dir, name, false) {
+			continue
+		}
+		filename := filepath.Join(dir, name)
+		if src, ok := ov.get(filename); ok {
+			stamps = append(stamps, fileStamp{name: name, hash: hashContents(src)})
+			continue
+		}
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{name: name, modTime: fi.ModTime(), size: fi.Size()})
+	}
+	return stamps, nil
+}
+
+func sameStamps(a, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].name != b[i].name || !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size || a[i].hash != b[i].hash {
+			return false
+		}
+	}
+	return true
+}
+
+// sourceImporterFrom imports packages using export data when it is
+// available and from source otherwise. A new one is used for each
+// query; the packages it imports from source are cached in the
+// Resolver.
+type sourceImporterFrom struct {
+	types.ImporterFrom
+
+	r       *Resolver
+	ctxt    *build.Context
+	overlay overlay
+
+	// srcDir is the directory of the query's package, which is used
+	// to resolve imports for which no source directory is given.
+	srcDir string
+
+	// modRoot and mod are the root directory and go.mod file of the
+	// main module (the one containing srcDir), or mod is nil in GOPATH
+	// mode. As with the go command, the imports of every package,
+	// including dependencies, are resolved using the main module's
+	// requirements and replacements.
+	modRoot string
+	mod     *modFile
+
+	// fresh records which cached packages were found to be up to
+	// date during this query, so each is only checked once.
+	fresh map[importerPkgKey]bool
+}
+
+func (s *sourceImporterFrom) Import(path string) (*types.Package, error) {
+	return s.ImportFrom(path, s.srcDir, 0)
+}
+
+var _ (types.ImporterFrom) = (*sourceImporterFrom)(nil)
+
+func (s *sourceImporterFrom) ImportFrom(path, srcDir string, mode types.ImportMode) (*types.Package, error) {
+	pkg, _, err := s.importFrom(path, srcDir, mode)
+	return pkg, err
+}
+
+// importFrom imports the package. If it was imported from source,
+// key is its key in the Resolver's import cache.
+func (s *sourceImporterFrom) importFrom(path, srcDir string, mode types.ImportMode) (pkg *types.Package, key *importerPkgKey, err error) {
+	if srcDir == "" {
+		srcDir = s.srcDir
+	}
+	if path == "unsafe" {
+		// Its source only documents it; go/types implements it.
+		return types.Unsafe, nil, nil
+	}
+
+	// In module mode, only standard library packages (and packages
+	// compiled with GoBuild) have export data, and looking for it
+	// runs the go command, so skip straight to source for others.
+	root, mod := s.modRoot, s.mod
+	useExport := mod == nil || s.r.hasExport(path) || isDir(filepath.Join(build.Default.GOROOT, "src", path))
+	if useExport && s.r.useExportData() {
+		pkg, err = s.ImporterFrom.ImportFrom(path, srcDir, mode)
+		if pkg != nil {
+			return pkg, nil, err
+		}
+	}
+
+	// Otherwise, import from source.
+	dir, err := packageDir(s.ctxt, path, srcDir, root, mod)
+	if err != nil {
+		return nil, nil, err
+	}
+	k := importerPkgKey{path, dir}
+	if e := s.r.cache.get(k); e != nil && e.pkg.Complete() && s.isFresh(k, e) {
+		return e.pkg, &k, nil
+	}
+
+	t0 := time.Now()
+	defer func() {
+		s.r.dlog.Printf("source import of %s from %s took %s", path, dir, time.Since(t0))
+	}()
+
+	stamps, err := stampDir(s.ctxt, dir, s.overlay)
+	if err != nil {
+		return nil, nil, err
+	}
+	pkgs, err := s.r.parseDir(dir, func(name string) bool {
+		return matchFile(s.ctxt, dir, name, false)
+	}, s.overlay)
+	if pkgs == nil {
+		return nil, nil, err
+	}
+	pkgFiles, err := choosePackage(path, dir, pkgs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	deps := &depImporter{s: s}
+	conf := types.Config{
+		Importer:                 deps,
+		Sizes:                    types.SizesFor("gc", s.ctxt.GOARCH),
+		FakeImportC:              true,
+		IgnoreFuncBodies:         true,
+		DisableUnusedImportCheck: true,
+		Error:                    func(error) {},
+	}
+	pkg, err = conf.Check(path, s.r.fset, pkgFiles, nil)
+	if pkg != nil {
+		pkg = s.r.cache.add(k, &importEntry{pkg: pkg, dir: dir, files: stamps, deps: deps.keys})
+	}
+	return pkg, &k, err
+}
+
+// isFresh reports whether the cached entry e for key, and every
+// source-imported package it depends on, is up to date with the files
+// on disk. Stale packages are dropped from the cache along with the
+// packages that import them.
+func (s *sourceImporterFrom) isFresh(key importerPkgKey, e *importEntry) bool {
+	if fresh, ok := s.fresh[key]; ok {
+		return fresh
+	}
+	if s.fresh == nil {
+		s.fresh = map[importerPkgKey]bool{}
+	}
+	s.fresh[key] = true // guard against (invalid) import cycles
+
+	stamps, err := stampDir(s.ctxt, e.dir, s.overlay)
+	fresh := err == nil && sameStamps(e.files, stamps)
+	for _, dep := range e.deps {
+		if !fresh {
+			break
+		}
+		de := s.r.cache.get(dep)
+		fresh = de != nil && s.isFresh(dep, de)
+	}
+	s.fresh[key] = fresh
+	if !fresh {
+		s.r.dlog.Printf("source import of %s is stale", key.path)
+		s.r.cache.invalidate(key)
+	}
+	return fresh
+}
+
+// depImporter imports a source-imported package's dependencies and
+// records which of them were imported from source.
+type depImporter struct {
+	s    *sourceImporterFrom
+	keys []importerPkgKey
+}
+
+func (d *depImporter) Import(path string) (*types.Package, error) {
+	return d.ImportFrom(path, "", 0)
+}
+
+func (d *depImporter) ImportFrom(path, srcDir string, mode types.ImportMode) (*types.Package, error) {
+	pkg, key, err := d.s.importFrom(path, srcDir, mode)
+	if key != nil {
+		d.keys = append(d.keys, *key)
+	}
+	return pkg, err
+}
+
+// packageDir returns the source directory of the package with the
+// given import path, as imported from srcDir. If mod is non-nil, it is
+// the main module, rooted at root; otherwise vendor directories are
+// searched from srcDir up.
+func packageDir(ctxt *build.Context, path, srcDir, root string, mod *modFile) (string, error) {
+	if mod != nil {
+		if dir, ok := mod.packageDir(root, path, srcDir); ok {
+			return dir, nil
+		}
+		return "", fmt.Errorf("cannot find package %q in module %s (or its dependencies)", path, mod.path)
+	}
+	bpkg, err := ctxt.Import(path, srcDir, build.FindOnly)
+	if err != nil {
+		return "", err
+	}
+	return bpkg.Dir, nil
+}
+
+// choosePackage returns the files of the package with the given import
+// path among the packages found in its directory. Test packages are
+// never chosen. If there is more than one candidate (eg, because of a
+// "// +build ignore" file with a different package name), the one
+// named like the last element of the import path is preferred, then
+// any package other than main.
+func choosePackage(path, dir string, pkgs map[string][]*ast.File) ([]*ast.File, error) {
+	var names []string
+	for name := range pkgs {
+		if !strings.HasSuffix(name, "_test") {
+			names = append(names, name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return nil, fmt.Errorf("no Go package found for import path %q in %s", path, dir)
+	case 1:
+		return pkgs[names[0]], nil
+	}
+
+	if files, ok := pkgs[guessPackageName(path)]; ok {
+		return files, nil
+	}
+	var nonMain []string
+	for _, name := range names {
+		if name != "main" {
+			nonMain = append(nonMain, name)
+		}
+	}
+	if len(nonMain) == 1 {
+		return pkgs[nonMain[0]], nil
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("found multiple packages %s for import path %q in %s", strings.Join(names, ", "), path, dir)
+}
+
+// guessPackageName returns the conventional package name for the
+// import path: its last element, ignoring a major version suffix and
+// any "go-" prefix or ".go" suffix.
+func guessPackageName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = elems[len(elems)-2]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	name = strings.TrimSuffix(name, ".go")
+	return strings.Replace(name, "-", "_", -1)
+}
+
+// listExports runs `go list -export -deps` on the package in dir. This
+// compiles the package and its dependencies (like the `go build -i`
+// of old) and records where their export data is, so that the
+// importer can use it.
+func (r *Resolver) listExports(ctx context.Context, dir string) error {
+	args := []string{"list", "-e", "-export", "-deps", "-f", "{{if .Export}}{{.ImportPath}}={{.Export}}{{end}}"}
+	if len(r.opts.BuildTags) > 0 {
+		args = append(args, "-tags", strings.Join(r.opts.BuildTags, ","))
+	}
+	cmd := exec.CommandContext(ctx, "go", append(args, ".")...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOPROXY=off")
+	if r.opts.GOOS != "" {
+		cmd.Env = append(cmd.Env, "GOOS="+r.opts.GOOS)
+	}
+	if r.opts.GOARCH != "" {
+		cmd.Env = append(cmd.Env, "GOARCH="+r.opts.GOARCH)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	r.exportsMu.Lock()
+	defer r.exportsMu.Unlock()
+	if r.exports == nil {
+		r.exports = map[string]string{}
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if i := strings.Index(line, "="); i > 0 {
+			r.exports[line[:i]] = line[i+1:]
+		}
+	}
+	return nil
+}
+
+func (r *Resolver) hasExport(path string) bool {
+	r.exportsMu.Lock()
+	defer r.exportsMu.Unlock()
+	_, ok := r.exports[path]
+	return ok
+}
+
+// lookupExport opens the export data file for the package, as found
+// by listExports.
+func (r *Resolver) lookupExport(path string) (io.ReadCloser, error) {
+	r.exportsMu.Lock()
+	file, ok := r.exports[path]
+	r.exportsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no export data for %q", path)
+	}
+	return os.Open(file)
+}
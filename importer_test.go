@@ -0,0 +1,319 @@
+package godefinfo
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFiles writes files (keyed by slash-separated path relative to
+// dir) to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, src := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// withGOPATH sets the GOPATH used to find packages for the duration
+// of the test.
+func withGOPATH(t *testing.T, gopath string) {
+	orig := build.Default.GOPATH
+	build.Default.GOPATH = gopath
+	t.Cleanup(func() { build.Default.GOPATH = orig })
+}
+
+func TestImportCacheInvalidation(t *testing.T) {
+	gopath := t.TempDir()
+	withGOPATH(t, gopath)
+	t.Setenv("GO111MODULE", "off")
+	writeFiles(t, filepath.Join(gopath, "src"), map[string]string{
+		"dep/dep.go":     "package dep\n\ntype T struct{ A int }\n",
+		"top/top.go":     "package top\n\nimport \"dep\"\n\nfunc F() dep.T { return dep.T{} }\n",
+		"other/other.go": "package other\n\nfunc G() {}\n",
+		"p/p.go":         "package p\n",
+	})
+	filename := filepath.Join(gopath, "src", "p", "p.go")
+
+	r := NewResolver(Options{Strict: true, ImportSrc: true})
+	resolve := func(src, ident string) string {
+		def, err := r.Resolve(context.Background(), Query{
+			Filename: filename,
+			Src:      []byte(src),
+			Offset:   strings.LastIndex(src, ident) + 1,
+		})
+		if err != nil {
+			t.Fatalf("resolving %q: %s", ident, err)
+		}
+		return def.String()
+	}
+
+	const src = "package p\n\nimport (\n\t\"other\"\n\t\"top\"\n)\n\nvar _, _ = top.F().%s, other.G\n"
+	if got, want := resolve(fmt.Sprintf(src, "A"), "A"), "dep T A"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	otherPkg := r.cache.get(importerPkgKey{"other", filepath.Join(gopath, "src", "other")}).pkg
+
+	// Edit dep (which top imports) and check that both are reloaded.
+	// Ensure the modification time changes even on file systems with
+	// coarse timestamps.
+	depFile := filepath.Join(gopath, "src", "dep", "dep.go")
+	writeFiles(t, filepath.Join(gopath, "src"), map[string]string{
+		"dep/dep.go": "package dep\n\ntype T struct{ B, C int }\n",
+	})
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(depFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resolve(fmt.Sprintf(src, "B"), "B"), "dep T B"; got != want {
+		t.Errorf("after edit: got %q, want %q", got, want)
+	}
+
+	// Packages unaffected by the edit are still cached.
+	if e := r.cache.get(importerPkgKey{"other", filepath.Join(gopath, "src", "other")}); e == nil || e.pkg != otherPkg {
+		t.Errorf("unchanged package %q was reloaded", "other")
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	gopath := t.TempDir()
+	withGOPATH(t, gopath)
+	srcDir := filepath.Join(gopath, "src")
+	writeFiles(t, srcDir, map[string]string{
+		"dep/dep.go": "package dep\n\ntype T struct{ A int }\n",
+		"p/p.go":     "package p\n",
+	})
+
+	// The overlay edits an imported package and adds a file to the
+	// query's package that doesn't exist on disk.
+	const src = "package p\n\nvar _ = Q().B\n"
+	var archive strings.Builder
+	for _, file := range []struct{ name, src string }{
+		{filepath.Join(srcDir, "dep", "dep.go"), "package dep\n\ntype T struct{ A, B int }\n"},
+		{filepath.Join(srcDir, "p", "q.go"), "package p\n\nimport \"dep\"\n\nfunc Q() dep.T { return dep.T{} }\n"},
+	} {
+		fmt.Fprintf(&archive, "%s\n%d\n%s", file.name, len(file.src), file.src)
+	}
+	overlay, err := ParseOverlayArchive(strings.NewReader(archive.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overlay) != 2 {
+		t.Fatalf("got %d files in overlay archive, want 2", len(overlay))
+	}
+
+	r := NewResolver(Options{Strict: true, ImportSrc: true})
+	def, err := r.Resolve(context.Background(), Query{
+		Filename: filepath.Join(srcDir, "p", "p.go"),
+		Src:      []byte(src),
+		Offset:   strings.Index(src, "B") + 1,
+		Overlay:  overlay,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := def.String(), "dep T B"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestVendor(t *testing.T) {
+	t.Setenv("GO111MODULE", "off")
+	gopath := t.TempDir()
+	withGOPATH(t, gopath)
+	srcDir := filepath.Join(gopath, "src")
+	writeFiles(t, srcDir, map[string]string{
+		"top/top.go":                         "package top\n\nimport \"dep\"\n\nvar _ = dep.T{}.Top.DepInner\n",
+		"top/vendor/dep/dep.go":              "package dep\n\nimport \"inner\"\n\ntype T struct{ Top inner.I }\n",
+		"top/vendor/dep/vendor/inner/i.go":   "package inner\n\ntype I struct{ DepInner int }\n",
+		"top/vendor/inner/i.go":              "package inner\n\ntype I struct{ TopInner int }\n",
+		"top/sub/sub.go":                     "package sub\n\nimport \"dep\"\n\nvar _ = dep.T{}.Sub\n",
+		"top/sub/vendor/dep/dep.go":          "package dep\n\ntype T struct{ Sub int }\n",
+		"top/sub/vendor/dep/gen.go":          "// +build ignore\n\npackage gen\n",
+		"top/sub/vendor/dep/dep_ext_test.go": "package dep_test\n",
+	})
+
+	r := NewResolver(Options{Strict: true, ImportSrc: true})
+	tests := []struct {
+		file, ident, want string
+	}{
+		{"top/top.go", "Top", "dep T Top"},
+		{"top/top.go", "DepInner", "inner I DepInner"},
+		{"top/sub/sub.go", "Sub", "dep T Sub"},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(srcDir, filepath.FromSlash(test.file))
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		def, err := r.Resolve(context.Background(), Query{
+			Filename: filename,
+			Offset:   strings.LastIndex(string(src), test.ident) + 1,
+		})
+		if err != nil {
+			t.Errorf("%s: %s: %s", test.file, test.ident, err)
+			continue
+		}
+		if got := def.String(); got != test.want {
+			t.Errorf("%s: %s: got %q, want %q", test.file, test.ident, got, test.want)
+		}
+	}
+}
+
+// TestMainModuleRequirements tests that the imports of dependencies
+// are resolved with the main module's requirements and replacements,
+// not the dependency's own, and that packages in a module nested in
+// the main module's tree are not taken from the main module.
+func TestMainModuleRequirements(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GO111MODULE", "on")
+	t.Setenv("GOMODCACHE", filepath.Join(dir, "modcache"))
+	writeFiles(t, dir, map[string]string{
+		"main/go.mod":        "module example.com/main\n\nrequire (\n\texample.com/dep v1.0.0\n\texample.com/main/nested v1.0.0\n\texample.com/z v1.2.0\n)\n\nreplace example.com/z v1.2.0 => ../zfork\n",
+		"main/main.go":       "package main\n\nimport (\n\t\"example.com/dep\"\n\t\"example.com/main/nested\"\n)\n\nvar _ = dep.V.Fork\nvar _ = nested.FromCache\n",
+		"main/nested/go.mod": "module example.com/main/nested\n",
+		"main/nested/n.go":   "package nested\n\nconst FromTree = 1\n",
+		"zfork/go.mod":       "module example.com/z\n",
+		"zfork/z.go":         "package z\n\ntype T struct{ Fork int }\n",
+
+		"modcache/example.com/dep@v1.0.0/go.mod":         "module example.com/dep\n\nrequire example.com/z v1.0.0\n\nreplace example.com/z => example.com/z v1.1.0\n",
+		"modcache/example.com/dep@v1.0.0/dep.go":         "package dep\n\nimport \"example.com/z\"\n\nvar V z.T\n",
+		"modcache/example.com/z@v1.0.0/go.mod":           "module example.com/z\n",
+		"modcache/example.com/z@v1.0.0/z.go":             "package z\n\ntype T struct{ Old int }\n",
+		"modcache/example.com/z@v1.1.0/go.mod":           "module example.com/z\n",
+		"modcache/example.com/z@v1.1.0/z.go":             "package z\n\ntype T struct{ Replaced int }\n",
+		"modcache/example.com/main/nested@v1.0.0/go.mod": "module example.com/main/nested\n",
+		"modcache/example.com/main/nested@v1.0.0/n.go":   "package nested\n\nconst FromCache = 1\n",
+	})
+	filename := filepath.Join(dir, "main", "main.go")
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewResolver(Options{Strict: true, ImportSrc: true})
+	tests := []struct {
+		ident, want, wantDir string
+	}{
+		{"Fork", "example.com/z T Fork", "zfork"},
+		{"FromCache", "example.com/main/nested FromCache", "modcache/example.com/main/nested@v1.0.0"},
+	}
+	for _, test := range tests {
+		def, err := r.Resolve(context.Background(), Query{
+			Filename: filename,
+			Offset:   strings.Index(string(src), test.ident) + 1,
+		})
+		if err != nil {
+			t.Errorf("%s: %s", test.ident, err)
+			continue
+		}
+		if got := def.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.ident, got, test.want)
+		}
+		if got, want := filepath.Dir(def.Filename), filepath.Join(dir, filepath.FromSlash(test.wantDir)); got != want {
+			t.Errorf("%s: got definition in %s, want %s", test.ident, got, want)
+		}
+	}
+}
+
+func TestBuildConstraints(t *testing.T) {
+	t.Setenv("GO111MODULE", "off")
+	gopath := t.TempDir()
+	withGOPATH(t, gopath)
+	writeFiles(t, filepath.Join(gopath, "src"), map[string]string{
+		"p/p.go":         "package p\n\nimport (\n\t\"dep\"\n\t\"net\"\n)\n\nvar _ = F\nvar _ = dep.T{}.OS\nvar _ net.IP\n\nfunc H() { G() }\n",
+		"p/f_linux.go":   "package p\n\nfunc F() {}\n",
+		"p/f_windows.go": "package p\n\nfunc F() {}\n",
+		"p/ignored.go":   "//go:build ignore\n\npackage main\n\nfunc F() {}\n",
+		"p/g.go":         "//go:build foo\n\npackage p\n\nfunc G() {}\n",
+		"p/g_other.go":   "//go:build !foo\n\npackage p\n\nfunc G() {}\n",
+		"dep/linux.go":   "//go:build linux\n\npackage dep\n\ntype T struct{ OS int }\n",
+		"dep/windows.go": "//go:build windows\n\npackage dep\n\ntype T struct{ OS string }\n",
+	})
+	filename := filepath.Join(gopath, "src", "p", "p.go")
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offsetOf := func(s string) int { return strings.Index(string(src), s) + 1 }
+
+	tests := []struct {
+		opts     Options
+		ident    string
+		wantFile string
+	}{
+		{Options{GOOS: "linux"}, "F", "f_linux.go"},
+		{Options{GOOS: "windows"}, "F", "f_windows.go"},
+		{Options{GOOS: "linux"}, "OS", "linux.go"},
+		{Options{GOOS: "windows", GOARCH: "386"}, "OS", "windows.go"},
+		{Options{}, "G", "g_other.go"},
+		{Options{BuildTags: []string{"foo"}}, "G", "g.go"},
+
+		// The standard library (including its vendored packages and
+		// unsafe) is imported from source, since its export data is
+		// for the host.
+		{Options{BuildTags: []string{"foo"}}, "IP", "ip.go"},
+		{Options{GOOS: "windows"}, "IP", "ip.go"},
+	}
+	for _, test := range tests {
+		test.opts.ImportSrc = true
+		test.opts.Strict = true
+		def, err := NewResolver(test.opts).Resolve(context.Background(), Query{
+			Filename: filename,
+			Offset:   offsetOf(test.ident),
+		})
+		if err != nil {
+			t.Errorf("%+v: %s: %s", test.opts, test.ident, err)
+			continue
+		}
+		if got := filepath.Base(def.Filename); got != test.wantFile {
+			t.Errorf("%+v: %s: got definition in %s, want %s", test.opts, test.ident, got, test.wantFile)
+		}
+	}
+
+	// In module mode, too, the standard library finds its vendored
+	// packages in GOROOT.
+	t.Setenv("GO111MODULE", "on")
+	modDir := t.TempDir()
+	writeFiles(t, modDir, map[string]string{
+		"go.mod": "module example.com/m\n",
+		"m.go":   "package m\n\nimport \"net\"\n\nvar _ net.IP\n",
+	})
+	def, err := NewResolver(Options{Strict: true, ImportSrc: true, GOOS: "windows"}).Resolve(context.Background(), Query{
+		Filename: filepath.Join(modDir, "m.go"),
+		Offset:   len("package m\n\nimport \"net\"\n\nvar _ net.") + 1,
+	})
+	if err != nil {
+		t.Fatalf("module mode: %s", err)
+	}
+	if got := filepath.Base(def.Filename); got != "ip.go" {
+		t.Errorf("module mode: got definition in %s, want ip.go", got)
+	}
+}
+
+func TestGuessPackageName(t *testing.T) {
+	tests := map[string]string{
+		"fmt":                    "fmt",
+		"github.com/a/go-bar":    "bar",
+		"example.com/mod/v2":     "mod",
+		"example.com/foo-bar.go": "foo_bar",
+		"example.com/vendor/v":   "v",
+	}
+	for path, want := range tests {
+		if got := guessPackageName(path); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package godefinfo
+
+import (
+	"context"
+	"encoding/gob"
+	"go/token"
+	"io"
+	"sort"
+	"strings"
+)
+
+// indexVersion is the version of the on-disk index format. It is
+// incremented whenever the format changes.
+const indexVersion = 1
+
+// An Index is a cross-reference index of the packages in a directory
+// tree: the definitions that their identifiers refer to, identified
+// by descriptors in the form that DefInfo.String returns, and where
+// each is declared and referred to. Once built (by BuildIndex) and
+// written, an Index can be read back and queried without parsing or
+// type-checking anything.
+type Index struct {
+	data indexData
+
+	byDesc map[string][]*indexDef
+	byFile map[string][]indexSpan // sorted by offset
+}
+
+// indexData is the on-disk (gob) form of an Index. Files are stored
+// once, and referred to by their index in Files.
+type indexData struct {
+	Version int
+	Root    string
+	Files   []string // absolute filenames
+	Defs    []*indexDef
+}
+
+type indexDef struct {
+	Desc string // descriptor, as returned by DefInfo.String
+	Kind Kind
+
+	// Decl is the position of the declaring identifier. Its File is
+	// -1 if the position is unknown (eg, for builtins and packages).
+	Decl indexPos
+
+	Refs []indexPos
+}
+
+// indexPos is the position of an identifier in an indexed file.
+type indexPos struct {
+	File              int
+	Offset, EndOffset int // 1-based byte offsets, like Reference's
+	Line, Column      int
+}
+
+// indexSpan is an identifier in a file, and the definition that it
+// declares or refers to.
+type indexSpan struct {
+	pos indexPos
+	def *indexDef
+}
+
+// BuildIndex type-checks every package (with its tests) in root and
+// its subdirectories, skipping the same directories as References,
+// and indexes the definition of every identifier in them.
+func (r *Resolver) BuildIndex(ctx context.Context, root string) (*Index, error) {
+	root = absPath(root)
+	ctxt := r.buildContext(nil)
+	dirs, err := packageDirs(ctxt, root, nil)
+	if err != nil {
+		return nil, wrapError(ErrIO, "listing packages", err)
+	}
+
+	ix := &Index{data: indexData{Version: indexVersion, Root: root}}
+	files := map[string]int{}
+	fileIndex := func(filename string) int {
+		i, ok := files[filename]
+		if !ok {
+			i = len(ix.data.Files)
+			files[filename] = i
+			ix.data.Files = append(ix.data.Files, filename)
+		}
+		return i
+	}
+	type defKey struct {
+		desc   string
+		file   string
+		offset int
+	}
+	defs := map[defKey]*indexDef{}
+
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pkgs, err := r.checkDir(ctxt, dir, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pkgs {
+			for _, f := range p.files {
+				tf := r.fset.File(f.Pos())
+				if tf == nil {
+					continue
+				}
+				anns, err := p.Annotate(tf.Name())
+				if err != nil {
+					return nil, err
+				}
+				file := fileIndex(absPath(tf.Name()))
+				for _, ann := range anns {
+					k := defKey{ann.DefInfo.String(), ann.Filename, ann.DefInfo.Offset}
+					def := defs[k]
+					if def == nil {
+						def = &indexDef{Desc: k.desc, Kind: ann.Kind, Decl: indexPos{File: -1}}
+						if ann.DefInfo.Offset != 0 {
+							def.Decl = indexPos{
+								File:      fileIndex(ann.Filename),
+								Offset:    ann.DefInfo.Offset,
+								EndOffset: ann.DefInfo.EndOffset,
+								Line:      ann.Line,
+								Column:    ann.Column,
+							}
+						}
+						defs[k] = def
+						ix.data.Defs = append(ix.data.Defs, def)
+					}
+					if ann.Filename == ix.data.Files[file] && ann.DefInfo.Offset == ann.Start {
+						// The declaration itself. (An embedded field is
+						// both a declaration and a reference to its
+						// type, which is declared elsewhere.)
+						continue
+					}
+					pos := tf.Position(tf.Pos(ann.Start - 1))
+					def.Refs = append(def.Refs, indexPos{
+						File:      file,
+						Offset:    ann.Start,
+						EndOffset: ann.End,
+						Line:      pos.Line,
+						Column:    pos.Column,
+					})
+				}
+			}
+		}
+	}
+	ix.init()
+	return ix, nil
+}
+
+// Write writes the index to w in a compact binary form that ReadIndex
+// reads.
+func (ix *Index) Write(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(&ix.data)
+}
+
+// ReadIndex reads an index written by Index.Write.
+func ReadIndex(r io.Reader) (*Index, error) {
+	ix := &Index{}
+	if err := gob.NewDecoder(r).Decode(&ix.data); err != nil {
+		return nil, wrapError(ErrIO, "reading index", err)
+	}
+	if ix.data.Version != indexVersion {
+		return nil, errorf(ErrIO, "index has version %d (want %d); rebuild it", ix.data.Version, indexVersion)
+	}
+	ix.init()
+	return ix, nil
+}
+
+// Root returns the directory that the index was built from.
+func (ix *Index) Root() string {
+	return ix.data.Root
+}
+
+// init builds the index's lookup tables.
+func (ix *Index) init() {
+	ix.byDesc = map[string][]*indexDef{}
+	ix.byFile = map[string][]indexSpan{}
+	add := func(pos indexPos, def *indexDef) {
+		if pos.File >= 0 && pos.File < len(ix.data.Files) {
+			name := ix.data.Files[pos.File]
+			ix.byFile[name] = append(ix.byFile[name], indexSpan{pos, def})
+		}
+	}
+	for _, def := range ix.data.Defs {
+		ix.byDesc[def.Desc] = append(ix.byDesc[def.Desc], def)
+		add(def.Decl, def)
+		for _, ref := range def.Refs {
+			add(ref, def)
+		}
+	}
+	for _, spans := range ix.byFile {
+		sort.Slice(spans, func(i, j int) bool { return spans[i].pos.Offset < spans[j].pos.Offset })
+	}
+}
+
+// at returns the definition that the identifier at the 1-based byte
+// offset in the named file declares or refers to.
+func (ix *Index) at(filename string, offset int) (*indexDef, error) {
+	spans := ix.byFile[absPath(filename)]
+	if spans == nil {
+		return nil, errorf(ErrNotFound, "%s is not indexed", filename)
+	}
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].pos.Offset > offset }) - 1
+	if i < 0 || offset >= spans[i].pos.EndOffset {
+		return nil, errorf(ErrNoIdentifier, "no indexed identifier at %s:%d", filename, offset)
+	}
+	return spans[i].def, nil
+}
+
+// Definition returns the definition of the identifier at the 1-based
+// byte offset in the named file, like Resolver.Resolve (but without
+// the fields that require type information, such as Type and
+// Signature).
+func (ix *Index) Definition(filename string, offset int) (*DefInfo, error) {
+	def, err := ix.at(filename, offset)
+	if err != nil {
+		return nil, err
+	}
+	return ix.defInfo(def), nil
+}
+
+// References returns the references to the definition of the
+// identifier at the 1-based byte offset in the named file, like
+// Package.References over the index's root.
+func (ix *Index) References(filename string, offset int) ([]*Reference, error) {
+	def, err := ix.at(filename, offset)
+	if err != nil {
+		return nil, err
+	}
+	return ix.references([]*indexDef{def}), nil
+}
+
+// Lookup returns the definitions with the descriptor desc. There may
+// be more than one for a local (eg, two variables named x in a func).
+func (ix *Index) Lookup(desc string) ([]*DefInfo, error) {
+	defs, err := ix.lookup(desc)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*DefInfo, len(defs))
+	for i, def := range defs {
+		infos[i] = ix.defInfo(def)
+	}
+	return infos, nil
+}
+
+// LookupReferences returns the references to the definitions with the
+// descriptor desc.
+func (ix *Index) LookupReferences(desc string) ([]*Reference, error) {
+	defs, err := ix.lookup(desc)
+	if err != nil {
+		return nil, err
+	}
+	return ix.references(defs), nil
+}
+
+func (ix *Index) lookup(desc string) ([]*indexDef, error) {
+	defs := ix.byDesc[strings.Join(strings.Fields(desc), " ")]
+	if defs == nil {
+		return nil, errorf(ErrNotFound, "%q is not indexed", desc)
+	}
+	return defs, nil
+}
+
+func (ix *Index) defInfo(def *indexDef) *DefInfo {
+	d := newDefInfo(strings.Fields(def.Desc)[0], strings.Fields(def.Desc)[1:]...)
+	d.Kind = def.Kind
+	d.Exported = token.IsExported(d.Name)
+	if def.Decl.File >= 0 {
+		d.Filename = ix.data.Files[def.Decl.File]
+		d.Line, d.Column = def.Decl.Line, def.Decl.Column
+		d.Offset, d.EndOffset = def.Decl.Offset, def.Decl.EndOffset
+	}
+	return d
+}
+
+// references returns the references to defs, sorted by filename and
+// offset.
+func (ix *Index) references(defs []*indexDef) []*Reference {
+	var refs []*Reference
+	for _, def := range defs {
+		for _, pos := range def.Refs {
+			refs = append(refs, &Reference{
+				Filename:  ix.data.Files[pos.File],
+				Line:      pos.Line,
+				Column:    pos.Column,
+				EndColumn: pos.Column + pos.EndOffset - pos.Offset,
+				Offset:    pos.Offset,
+				EndOffset: pos.EndOffset,
+			})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Filename != refs[j].Filename {
+			return refs[i].Filename < refs[j].Filename
+		}
+		return refs[i].Offset < refs[j].Offset
+	})
+	return refs
+}
@@ -0,0 +1,132 @@
+package godefinfo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	const depSrc = `package dep
+
+type T struct{ F int }
+
+func (t *T) M() int { return t.F }
+`
+	const useSrc = `package use
+
+import "dep"
+
+type U struct{ dep.T }
+
+func G(u U) int {
+	x := u.M()
+	return x + u.F + len("")
+}
+`
+	gopath := t.TempDir()
+	withGOPATH(t, gopath)
+	t.Setenv("GO111MODULE", "off")
+	src := filepath.Join(gopath, "src")
+	writeFiles(t, src, map[string]string{
+		"dep/dep.go":            depSrc,
+		"use/use.go":            useSrc,
+		"use/use_test.go":       "package use\n\nvar _ = G\n",
+		"use/anon.go":           "package use\n\nvar X interface{ M() }\n\nvar _ = X\n",
+		"testdata/bad/bad.go":   "package bad\n\nvar _ = dep.Nope\n",
+		"use/.hidden/hidden.go": "package hidden\n\nvar _ = G\n",
+	})
+	r := NewResolver(Options{Strict: true, ImportSrc: true})
+	built, err := r.BuildIndex(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := built.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	ix, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Root() != src {
+		t.Errorf("got root %q, want %q", ix.Root(), src)
+	}
+
+	// Every identifier's indexed definition agrees with Resolve.
+	useFile := filepath.Join(src, "use", "use.go")
+	p, err := r.Check(context.Background(), Query{Filename: useFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anns, err := p.Annotate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ann := range anns {
+		def, err := ix.Definition(useFile, ann.Start)
+		if err != nil {
+			t.Errorf("%d: %s", ann.Start, err)
+			continue
+		}
+		if def.String() != ann.DefInfo.String() || def.Filename != ann.Filename || def.Offset != ann.DefInfo.Offset {
+			t.Errorf("%d: got %s at %s:%d, want %s at %s:%d", ann.Start, def, def.Filename, def.Offset, ann.DefInfo, ann.Filename, ann.DefInfo.Offset)
+		}
+	}
+	if def, err := ix.Definition(useFile, strings.Index(useSrc, "func")+1); err == nil {
+		t.Errorf("keyword: got %s, want error", def)
+	}
+
+	refString := func(refs []*Reference) string {
+		var s []string
+		for _, ref := range refs {
+			rel, _ := filepath.Rel(src, ref.Filename)
+			s = append(s, fmt.Sprintf("%s:%d:%d-%d", filepath.ToSlash(rel), ref.Line, ref.Column, ref.EndColumn))
+		}
+		return strings.Join(s, " ")
+	}
+	tests := []struct {
+		desc string
+		want string
+	}{
+		{"dep T M", "use/use.go:8:9-10"},
+		{"dep T F", "dep/dep.go:5:32-33 use/use.go:9:15-16"},
+		{"use G", "use/use_test.go:3:9-10"},
+		{"use G x", "use/use.go:9:9-10"},
+		{"builtin len", "use/use.go:9:19-22"},
+		{"dep", "use/use.go:5:16-19"},
+		{"use X", "use/anon.go:5:9-10"}, // a package with an anonymous interface method is indexed
+	}
+	for _, test := range tests {
+		refs, err := ix.LookupReferences(test.desc)
+		if err != nil {
+			t.Errorf("%q: %s", test.desc, err)
+			continue
+		}
+		if got := refString(refs); got != test.want {
+			t.Errorf("%q: got references %s, want %s", test.desc, got, test.want)
+		}
+	}
+
+	if _, err := ix.LookupReferences("dep T Nope"); err == nil {
+		t.Error("references to unknown descriptor: got no error")
+	}
+
+	defs, err := ix.Lookup("dep T M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 1 || defs[0].Filename != filepath.Join(src, "dep", "dep.go") || defs[0].Line != 5 || defs[0].Kind != KindMethod {
+		t.Errorf("lookup: got %v", defs)
+	}
+	refs, err := ix.References(useFile, strings.Index(useSrc, "u.M")+3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := refString(refs), "use/use.go:8:9-10"; got != want {
+		t.Errorf("references at u.M: got %s, want %s", got, want)
+	}
+}
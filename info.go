@@ -1,50 +1,254 @@
-package main
+package godefinfo
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
-	"os"
+	"bytes"
+	"go/types"
 	"strings"
 )
 
-type defInfo struct {
+// SchemaVersion is the version of the JSON encoding of DefInfo. It is
+// incremented when fields are removed or change meaning (but not when
+// fields are added).
+const SchemaVersion = 1
+
+// DefInfo describes the definition of an identifier. Its JSON encoding
+// (see SchemaVersion) has a field for each exported field; fields
+// tagged omitempty are omitted when they are empty.
+type DefInfo struct {
 	Name    string
 	Package string
 
 	// Container is the object that a method or field is invoked upon.
 	Container string
 
+	// Kind is the kind of definition.
+	Kind Kind `json:",omitempty"`
+
+	// Exported is whether the definition is exported from its package.
+	Exported bool
+
+	// PointerReceiver is whether a method has a pointer receiver.
+	PointerReceiver bool `json:",omitempty"`
+
+	// Type is the type of the definition (for a type, its underlying
+	// type), eg "func(req *http.Request) (*http.Response, error)".
+	// Types in the definition's own package are unqualified; others
+	// are qualified by package name.
+	Type string `json:",omitempty"`
+
+	// Signature is the definition's declaration on one line, eg
+	// "func (c *Client) Do(req *Request) (*Response, error)" or
+	// "field Body io.ReadCloser".
+	Signature string `json:",omitempty"`
+
+	// Doc is the doc comment of the definition's declaration (if
+	// Options.Docs is set), eg "Body represents the response body.\n".
+	Doc string `json:",omitempty"`
+
 	// IsGoRepoPath describes whether a package can be found in GOROOT,
 	// eg fmt, net/http.
 	IsGoRepoPath bool
+
+	// Filename is the absolute name of the file that declares the
+	// definition, and Line and Column (1-based, in bytes) are the
+	// position of the declaring identifier in it. Offset and
+	// EndOffset delimit the identifier as byte offsets in the same
+	// 1-based convention as Query.Offset (EndOffset is exclusive).
+	// They are all zero if the position is unknown (eg, for builtins
+	// and packages).
+	Filename          string `json:",omitempty"`
+	Line, Column      int    `json:",omitempty"`
+	Offset, EndOffset int    `json:",omitempty"`
+
+	obj types.Object // the object that declares the definition, if known
+}
+
+func newDefInfo(pkg string, names ...string) *DefInfo {
+	info := &DefInfo{Package: pkg}
+	if len(names) > 1 {
+		info.Container = names[0]
+		info.Name = names[1]
+	} else if len(names) > 0 {
+		info.Name = names[0]
+	}
+	info.IsGoRepoPath = isGoRepoPath(info.Package)
+	return info
+}
+
+func (d *DefInfo) withObject(obj types.Object) *DefInfo {
+	d.obj = obj
+	return d
+}
+
+// Kind is a kind of definition.
+type Kind string
+
+// Kinds of definitions.
+const (
+	KindFunc    Kind = "func"
+	KindMethod  Kind = "method" // including interface methods
+	KindField   Kind = "field"  // including embedded fields
+	KindVar     Kind = "var"
+	KindConst   Kind = "const"
+	KindType    Kind = "type" // including type parameters
+	KindPackage Kind = "package"
+	KindBuiltin Kind = "builtin" // predeclared identifiers
+	KindLabel   Kind = "label"
+	KindLocal   Kind = "local" // declared in a function (other than labels)
+)
+
+// kind returns the kind of d, determined from its object.
+func (d *DefInfo) kind() Kind {
+	switch d.obj.(type) {
+	case nil:
+		if d.Package == "builtin" {
+			return KindBuiltin
+		}
+		if d.Container == "" && d.Name == "" {
+			return KindPackage
+		}
+		return ""
+	case *types.PkgName:
+		return KindPackage
+	case *types.Label:
+		return KindLabel
+	case *types.Builtin, *types.Nil:
+		return KindBuiltin
+	}
+	if isLocal(d.obj) {
+		return KindLocal
+	}
+	if d.obj.Pkg() == nil {
+		return KindBuiltin
+	}
+	switch obj := d.obj.(type) {
+	case *types.Func:
+		if obj.Type().(*types.Signature).Recv() != nil {
+			return KindMethod
+		}
+		return KindFunc
+	case *types.Var:
+		if obj.IsField() {
+			return KindField
+		}
+		return KindVar
+	case *types.Const:
+		return KindConst
+	case *types.TypeName:
+		return KindType
+	}
+	return ""
 }
 
-func outputData(data ...interface{}) {
-	output := fmt.Sprintln(data...)
-	if !*useJSON {
-		fmt.Print(output)
+// describe sets the fields of d that are derived from its object.
+func (d *DefInfo) describe() {
+	d.Kind = d.kind()
+	if d.obj == nil {
 		return
 	}
-	printStructured(output)
+	d.Exported = d.obj.Exported()
+
+	qf := func(p *types.Package) string {
+		if p == d.obj.Pkg() {
+			return ""
+		}
+		return p.Name()
+	}
+	switch obj := d.obj.(type) {
+	case *types.Builtin, *types.Label, *types.Nil, *types.PkgName:
+		// No (meaningful) type.
+	case *types.TypeName:
+		d.Type = types.TypeString(obj.Type().Underlying(), qf)
+	default:
+		d.Type = types.TypeString(obj.Type(), qf)
+	}
+	if fn, ok := d.obj.(*types.Func); ok {
+		if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+			_, d.PointerReceiver = recv.Type().(*types.Pointer)
+		}
+	}
+	d.Signature = signature(d.obj, qf)
 }
 
-func printStructured(output string) {
-	datas := strings.Split(strings.Trim(output, "\n"), " ")
-	info := defInfo{}
-	if len(datas) > 0 {
-		info.Package = datas[0]
+// signature returns the declaration of obj on one line.
+func signature(obj types.Object, qf types.Qualifier) string {
+	var buf bytes.Buffer
+	switch obj := obj.(type) {
+	case *types.Func:
+		sig := obj.Type().(*types.Signature)
+		buf.WriteString("func ")
+		if recv := sig.Recv(); recv != nil {
+			buf.WriteString("(")
+			if recv.Name() != "" && recv.Name() != "_" {
+				buf.WriteString(recv.Name() + " ")
+			}
+			types.WriteType(&buf, recv.Type(), qf)
+			buf.WriteString(") ")
+		}
+		buf.WriteString(obj.Name())
+		types.WriteSignature(&buf, sig, qf)
+
+	case *types.Var:
+		if obj.IsField() {
+			buf.WriteString("field ")
+		} else {
+			buf.WriteString("var ")
+		}
+		buf.WriteString(obj.Name() + " ")
+		types.WriteType(&buf, obj.Type(), qf)
+
+	case *types.Const:
+		buf.WriteString("const " + obj.Name() + " ")
+		types.WriteType(&buf, obj.Type(), qf)
+		buf.WriteString(" = " + obj.Val().String())
+
+	case *types.TypeName:
+		buf.WriteString("type " + obj.Name())
+		switch t := obj.Type().(type) {
+		case *types.TypeParam:
+			buf.WriteString(" ")
+			types.WriteType(&buf, t.Constraint(), qf)
+			return buf.String()
+		case *types.Named:
+			if tparams := t.TypeParams(); tparams.Len() > 0 {
+				buf.WriteString("[")
+				for i := 0; i < tparams.Len(); i++ {
+					if i > 0 {
+						buf.WriteString(", ")
+					}
+					tp := tparams.At(i)
+					buf.WriteString(tp.Obj().Name() + " ")
+					types.WriteType(&buf, tp.Constraint(), qf)
+				}
+				buf.WriteString("]")
+			}
+		}
+		if obj.IsAlias() {
+			buf.WriteString(" = ")
+			types.WriteType(&buf, types.Unalias(obj.Type()), qf)
+		} else {
+			buf.WriteString(" ")
+			types.WriteType(&buf, obj.Type().Underlying(), qf)
+		}
+
+	case *types.Label:
+		buf.WriteString("label " + obj.Name())
+
+	default:
+		return ""
 	}
-	if len(datas) > 2 {
-		info.Name = datas[2]
-		info.Container = datas[1]
-	} else if len(datas) > 1 {
-		info.Name = datas[1]
+	return buf.String()
+}
+
+// String returns the definition in godefinfo's plain-text output
+// format: "importpath [Container] Name", eg "net/http Response Body".
+func (d *DefInfo) String() string {
+	parts := []string{d.Package}
+	if d.Container != "" {
+		parts = append(parts, d.Container)
 	}
-	info.IsGoRepoPath = isGoRepoPath(info.Package)
-	bytes, err := json.MarshalIndent(info, "", "\t")
-	if err != nil {
-		log.Fatal(err)
+	if d.Name != "" {
+		parts = append(parts, d.Name)
 	}
-	os.Stdout.Write(bytes)
+	return strings.Join(parts, " ")
 }
@@ -0,0 +1,73 @@
+package godefinfo
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDescribe(t *testing.T) {
+	const src = `package p
+
+import "net/http"
+
+type T struct{ F int }
+
+func (t *T) M(c *http.Client) (*http.Response, error) { return nil, nil }
+
+type List[E any] []E
+
+type A = T
+
+const C = 1
+
+var _ = http.DefaultClient.Do
+var _ = (&T{}).M
+var _ = T{}.F
+var _ = List[int]{}
+var _ = A{}
+var _ = C
+var _ = len("")
+`
+	filename := filepath.Join(t.TempDir(), "p.go")
+	p, err := NewResolver(Options{Strict: true, ImportSrc: true}).Check(context.Background(), Query{Filename: filename, Src: []byte(src)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at   string // text that ends with the identifier
+		want DefInfo
+	}{
+		{"DefaultClient.Do", DefInfo{Kind: KindMethod, Exported: true, PointerReceiver: true,
+			Type:      "func(req *Request) (*Response, error)",
+			Signature: "func (c *Client) Do(req *Request) (*Response, error)"}},
+		{"{}).M", DefInfo{Kind: KindMethod, Exported: true, PointerReceiver: true,
+			Type:      "func(c *http.Client) (*http.Response, error)",
+			Signature: "func (t *T) M(c *http.Client) (*http.Response, error)"}},
+		{"T{}.F", DefInfo{Kind: KindField, Exported: true, Type: "int", Signature: "field F int"}},
+		{"_ = List", DefInfo{Kind: KindType, Exported: true, Type: "[]E", Signature: "type List[E any] []E"}},
+		{"_ = A", DefInfo{Kind: KindType, Exported: true, Type: "struct{F int}", Signature: "type A = T"}},
+		{"_ = C", DefInfo{Kind: KindConst, Exported: true, Type: "untyped int", Signature: "const C untyped int = 1"}},
+		{"_ = len", DefInfo{Kind: KindBuiltin}},
+		{"net/http", DefInfo{Kind: KindPackage}},
+	}
+	for _, test := range tests {
+		def, err := p.Resolve("", strings.Index(src, test.at)+len(test.at))
+		if err != nil {
+			t.Errorf("%s: %s", test.at, err)
+			continue
+		}
+		got := DefInfo{
+			Kind:            def.Kind,
+			Exported:        def.Exported,
+			PointerReceiver: def.PointerReceiver,
+			Type:            def.Type,
+			Signature:       def.Signature,
+		}
+		if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.at, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package godefinfo
+
+import (
+	"context"
+	"go/types"
+	"strings"
+)
+
+// Lookup finds the definition described by desc, a descriptor of the
+// form that DefInfo.String returns: "pkg" for a package, "pkg Name"
+// for a package-level definition, or "pkg Type Name" for a method or
+// field of a type (including one promoted from an embedded field) or
+// a type parameter of a generic type or func. The package is imported
+// as from a file in srcDir, from source if r's options allow it.
+//
+// The definition's position is set, except for packages. For a
+// promoted method or field, the returned DefInfo describes its
+// declaration, whose container is the embedded type. Descriptors of
+// locals (whose containers are funcs) are not supported.
+func (r *Resolver) Lookup(ctx context.Context, desc, srcDir string) (*DefInfo, error) {
+	parts := strings.Fields(desc)
+	if len(parts) == 0 || len(parts) > 3 {
+		return nil, errorf(ErrNotFound, "bad descriptor %q (want \"pkg\", \"pkg Name\" or \"pkg Type Name\")", desc)
+	}
+	if len(parts) == 1 {
+		return newDefInfo(parts[0]), nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	srcDir = absPath(srcDir)
+	imp := r.importer(r.buildContext(nil), nil, srcDir).(types.ImporterFrom)
+	pkg, err := imp.ImportFrom(parts[0], srcDir, 0)
+	if pkg == nil {
+		return nil, wrapError(ErrNotFound, "importing "+parts[0], err)
+	}
+
+	var def *DefInfo
+	if len(parts) == 2 {
+		obj := pkg.Scope().Lookup(parts[1])
+		if obj == nil {
+			return nil, errorf(ErrNotFound, "%s not found in package %s", parts[1], pkg.Path())
+		}
+		def = objectInfo(obj)
+	} else if def = memberInfo(pkg, parts[1], parts[2]); def == nil {
+		return nil, errorf(ErrNotFound, "%s %s not found in package %s", parts[1], parts[2], pkg.Path())
+	}
+
+	p := &Package{r: r, pkg: pkg}
+	p.complete(def)
+	return def, nil
+}
+
+// memberInfo returns the DefInfo of the method, field or type
+// parameter named name of the package-level type or func named
+// container in pkg, or nil if there is none.
+func memberInfo(pkg *types.Package, container, name string) *DefInfo {
+	obj := pkg.Scope().Lookup(container)
+	if obj == nil {
+		return nil
+	}
+
+	var tparams *types.TypeParamList
+	switch obj := obj.(type) {
+	case *types.TypeName:
+		t, ok := obj.Type().(*types.Named)
+		if !ok {
+			return nil
+		}
+		tparams = t.TypeParams()
+		m, index, _ := types.LookupFieldOrMethod(t, true, pkg, name)
+		switch m := m.(type) {
+		case *types.Func:
+			return methodOrTypeInfo(m)
+		case *types.Var:
+			if recv := fieldContainer(t, index); recv != nil {
+				return newDefInfo(m.Pkg().Path(), recv.Obj().Name(), m.Name()).withObject(m)
+			}
+			return newDefInfo(m.Pkg().Path(), container, m.Name()).withObject(m)
+		}
+	case *types.Func:
+		tparams = obj.Type().(*types.Signature).TypeParams()
+	}
+
+	for i := 0; i < tparams.Len(); i++ {
+		if tp := tparams.At(i); tp.Obj().Name() == name {
+			return newDefInfo(pkg.Path(), container, name).withObject(tp.Obj())
+		}
+	}
+	return nil
+}
+
+// fieldContainer returns the named struct type that declares the
+// field of t at the given index sequence (as returned by
+// types.LookupFieldOrMethod), following embedded fields, or nil if it
+// is not a named type.
+func fieldContainer(t types.Type, index []int) *types.Named {
+	for _, i := range index[:len(index)-1] {
+		s, ok := dereferenceType(types.Unalias(t)).Underlying().(*types.Struct)
+		if !ok {
+			return nil
+		}
+		t = s.Field(i).Type()
+	}
+	named, _ := dereferenceType(types.Unalias(t)).(*types.Named)
+	if named != nil {
+		named = named.Origin()
+	}
+	return named
+}
@@ -0,0 +1,83 @@
+package godefinfo
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	gopath := t.TempDir()
+	withGOPATH(t, gopath)
+	t.Setenv("GO111MODULE", "off")
+	src := filepath.Join(gopath, "src")
+	writeFiles(t, src, map[string]string{
+		"p/p.go": `package p
+
+type Inner struct {
+	F int
+}
+
+func (*Inner) M() {}
+
+type Outer struct {
+	*Inner
+	G string
+}
+
+type List[E any] []E
+
+func Map[T, U any](T) U { var u U; return u }
+
+var V = 1
+
+type I interface{ Do() }
+`,
+	})
+	r := NewResolver(Options{Strict: true, ImportSrc: true})
+
+	tests := []struct {
+		desc string
+		want string // descriptor and file:line:column of the definition
+	}{
+		{"p", "p"},
+		{"p V", "p V p/p.go:18:5"},
+		{"p Outer", "p Outer p/p.go:9:6"},
+		{"p Outer G", "p Outer G p/p.go:11:2"},
+		{"p Outer F", "p Inner F p/p.go:4:2"},
+		{"p Outer M", "p Inner M p/p.go:7:15"},
+		{"p List E", "p List E p/p.go:14:11"},
+		{"p Map U", "p Map U p/p.go:16:13"},
+		{"p I Do", "p I Do p/p.go:20:19"},
+	}
+	for _, test := range tests {
+		def, err := r.Lookup(context.Background(), test.desc, src)
+		if err != nil {
+			t.Errorf("%q: %s", test.desc, err)
+			continue
+		}
+		got := def.String()
+		if def.Filename != "" {
+			rel, _ := filepath.Rel(src, def.Filename)
+			got += fmt.Sprintf(" %s:%d:%d", filepath.ToSlash(rel), def.Line, def.Column)
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.desc, got, test.want)
+		}
+	}
+
+	def, err := r.Lookup(context.Background(), "net/http Response Body", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := def.String(), "net/http Response Body"; got != want || filepath.Base(def.Filename) != "response.go" || def.Offset == 0 {
+		t.Errorf("got %q at %s:%d, want %q in response.go", got, def.Filename, def.Offset, want)
+	}
+
+	for _, desc := range []string{"", "p X", "p Outer X", "p V X", "p a b c", "nonexistent X"} {
+		if def, err := r.Lookup(context.Background(), desc, src); err == nil {
+			t.Errorf("%q: got %s, want error", desc, def)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package godefinfo
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"go/token"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// lsifVersion is the version of the LSIF (Language Server Index
+// Format) specification that WriteLSIF follows.
+const lsifVersion = "0.4.3"
+
+// An lsifElement is a vertex or edge of an LSIF graph. Only the fields
+// that apply to its label are set.
+type lsifElement struct {
+	ID    int    `json:"id"`
+	Type  string `json:"type"` // "vertex" or "edge"
+	Label string `json:"label"`
+
+	// metaData
+	Version          string        `json:"version,omitempty"`
+	ProjectRoot      string        `json:"projectRoot,omitempty"`
+	PositionEncoding string        `json:"positionEncoding,omitempty"`
+	ToolInfo         *lsifToolInfo `json:"toolInfo,omitempty"`
+
+	// project, document, range, hoverResult and moniker
+	Kind       string     `json:"kind,omitempty"`
+	URI        string     `json:"uri,omitempty"`
+	LanguageID string     `json:"languageId,omitempty"`
+	Start      *lsifPos   `json:"start,omitempty"`
+	End        *lsifPos   `json:"end,omitempty"`
+	Result     *lsifHover `json:"result,omitempty"`
+	Scheme     string     `json:"scheme,omitempty"`
+	Identifier string     `json:"identifier,omitempty"`
+
+	// Edges.
+	OutV     int    `json:"outV,omitempty"`
+	InV      int    `json:"inV,omitempty"`
+	InVs     []int  `json:"inVs,omitempty"`
+	Document int    `json:"document,omitempty"`
+	Property string `json:"property,omitempty"`
+}
+
+type lsifToolInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// lsifPos is a 0-based line and UTF-16 character offset, as in LSP.
+type lsifPos struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lsifHover struct {
+	Contents []lsifMarkedString `json:"contents"`
+}
+
+type lsifMarkedString struct {
+	Language string `json:"language,omitempty"`
+	Value    string `json:"value"`
+}
+
+// MarshalJSON encodes a marked string without a language as a plain
+// string, as LSP requires.
+func (s lsifMarkedString) MarshalJSON() ([]byte, error) {
+	if s.Language == "" {
+		return json.Marshal(s.Value)
+	}
+	type markedString lsifMarkedString
+	return json.Marshal(markedString(s))
+}
+
+// lsifRange identifies a range vertex and the document it is in.
+type lsifRange struct {
+	id, doc int
+}
+
+// lsifDef is a definition in an LSIF dump: its result set and the
+// ranges that declare and refer to it.
+type lsifDef struct {
+	resultSet int
+	decl      *lsifRange
+	refs      []lsifRange
+}
+
+// lsifWriter writes the vertices and edges of an LSIF dump.
+type lsifWriter struct {
+	enc *json.Encoder
+	id  int
+	err error
+}
+
+func (w *lsifWriter) emit(e *lsifElement) int {
+	w.id++
+	e.ID = w.id
+	if w.err == nil {
+		w.err = w.enc.Encode(e)
+	}
+	return e.ID
+}
+
+func (w *lsifWriter) vertex(e lsifElement) int {
+	e.Type = "vertex"
+	return w.emit(&e)
+}
+
+// edge emits a one-to-one edge.
+func (w *lsifWriter) edge(label string, outV, inV int) int {
+	return w.emit(&lsifElement{Type: "edge", Label: label, OutV: outV, InV: inV})
+}
+
+// edges emits a one-to-many edge (e.InVs is set), such as "contains"
+// or "item".
+func (w *lsifWriter) edges(e lsifElement) int {
+	e.Type = "edge"
+	return w.emit(&e)
+}
+
+// WriteLSIF type-checks the packages matched by patterns, with their
+// tests, and writes an LSIF dump of them to w, as JSON lines: a
+// document for each file, a range for each identifier, and for each
+// definition a result set with its hover text, definition and
+// reference results and a moniker.
+//
+// Patterns are directories relative to root (which is the dump's
+// project root) or absolute; a pattern ending in "/..." also matches
+// the package directories below it, as with References. Monikers use
+// the "godefinfo" scheme and identify definitions by package,
+// container and name, as "net/http:Response.Body"; they are of kind
+// "export" for exported definitions in the dump, "import" for
+// definitions in other packages and "local" otherwise. Nothing is
+// fetched from the network.
+func (r *Resolver) WriteLSIF(ctx context.Context, w io.Writer, root string, patterns []string) error {
+	root = absPath(root)
+	ctxt := r.buildContext(nil)
+	var dirs []string
+	seenDir := map[string]bool{}
+	for _, pattern := range patterns {
+		dir, recursive := pattern, false
+		if pattern == "..." || strings.HasSuffix(pattern, "/...") {
+			dir, recursive = strings.TrimSuffix(strings.TrimSuffix(pattern, "..."), "/"), true
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		matches := []string{dir}
+		if recursive {
+			var err error
+			if matches, err = packageDirs(ctxt, dir, nil); err != nil {
+				return wrapError(ErrIO, "listing packages", err)
+			}
+		}
+		for _, dir := range matches {
+			if !seenDir[dir] {
+				seenDir[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	lw := &lsifWriter{enc: json.NewEncoder(bw)}
+	lw.vertex(lsifElement{
+		Label:            "metaData",
+		Version:          lsifVersion,
+		ProjectRoot:      fileURI(root),
+		PositionEncoding: "utf-16",
+		ToolInfo:         &lsifToolInfo{Name: "godefinfo", Version: "0.1"},
+	})
+	project := lw.vertex(lsifElement{Label: "project", Kind: "go"})
+
+	type defKey struct {
+		desc   string
+		file   string
+		offset int
+	}
+	defs := map[defKey]*lsifDef{}
+	var order []*lsifDef
+	var docs []int
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pkgs, err := r.checkDir(ctxt, dir, nil)
+		if err != nil {
+			return err
+		}
+		for _, p := range pkgs {
+			for _, f := range p.files {
+				tf := r.fset.File(f.Pos())
+				if tf == nil {
+					continue
+				}
+				filename := absPath(tf.Name())
+				anns, err := p.Annotate(tf.Name())
+				if err != nil {
+					return err
+				}
+				src, err := p.source(filename)
+				if err != nil {
+					return wrapError(ErrIO, "reading source file", err)
+				}
+
+				doc := lw.vertex(lsifElement{Label: "document", URI: fileURI(filename), LanguageID: "go"})
+				docs = append(docs, doc)
+				var ranges []int
+				for _, ann := range anns {
+					rng := lw.vertex(lsifElement{
+						Label: "range",
+						Start: lsifPosition(tf, src, ann.Start),
+						End:   lsifPosition(tf, src, ann.End),
+					})
+					ranges = append(ranges, rng)
+
+					k := defKey{ann.DefInfo.String(), ann.Filename, ann.DefInfo.Offset}
+					def := defs[k]
+					if def == nil {
+						def = &lsifDef{resultSet: lw.vertex(lsifElement{Label: "resultSet"})}
+						defs[k] = def
+						order = append(order, def)
+
+						hover := lw.vertex(lsifElement{Label: "hoverResult", Result: lsifHoverResult(ann.DefInfo)})
+						lw.edge("textDocument/hover", def.resultSet, hover)
+						moniker := lw.vertex(lsifElement{
+							Label:      "moniker",
+							Kind:       monikerKind(ann.DefInfo, seenDir),
+							Scheme:     "godefinfo",
+							Identifier: monikerIdentifier(ann.DefInfo),
+						})
+						lw.edge("moniker", def.resultSet, moniker)
+					}
+					lw.edge("next", rng, def.resultSet)
+
+					if ann.Filename == filename && ann.DefInfo.Offset == ann.Start {
+						def.decl = &lsifRange{rng, doc}
+					} else {
+						def.refs = append(def.refs, lsifRange{rng, doc})
+					}
+				}
+				if len(ranges) > 0 {
+					lw.edges(lsifElement{Label: "contains", OutV: doc, InVs: ranges})
+				}
+			}
+		}
+	}
+
+	for _, def := range order {
+		if def.decl != nil {
+			result := lw.vertex(lsifElement{Label: "definitionResult"})
+			lw.edge("textDocument/definition", def.resultSet, result)
+			lw.edges(lsifElement{Label: "item", OutV: result, InVs: []int{def.decl.id}, Document: def.decl.doc})
+		}
+
+		result := lw.vertex(lsifElement{Label: "referenceResult"})
+		lw.edge("textDocument/references", def.resultSet, result)
+		if def.decl != nil {
+			lw.edges(lsifElement{Label: "item", OutV: result, InVs: []int{def.decl.id}, Document: def.decl.doc, Property: "definitions"})
+		}
+		// Group the references by document, in order of appearance.
+		byDoc := map[int][]int{}
+		var refDocs []int
+		for _, ref := range def.refs {
+			if byDoc[ref.doc] == nil {
+				refDocs = append(refDocs, ref.doc)
+			}
+			byDoc[ref.doc] = append(byDoc[ref.doc], ref.id)
+		}
+		for _, doc := range refDocs {
+			lw.edges(lsifElement{Label: "item", OutV: result, InVs: byDoc[doc], Document: doc, Property: "references"})
+		}
+	}
+	if len(docs) > 0 {
+		lw.edges(lsifElement{Label: "contains", OutV: project, InVs: docs})
+	}
+
+	if lw.err != nil {
+		return wrapError(ErrIO, "writing LSIF dump", lw.err)
+	}
+	if err := bw.Flush(); err != nil {
+		return wrapError(ErrIO, "writing LSIF dump", err)
+	}
+	return nil
+}
+
+// lsifPosition returns the LSIF position of the 1-based byte offset in
+// src, the contents of tf.
+func lsifPosition(tf *token.File, src []byte, offset int) *lsifPos {
+	pos := tf.Position(tf.Pos(offset - 1))
+	start := offset - pos.Column // 0-based offset of the line's start
+	var char int
+	for i := start; i < offset-1 && i < len(src); {
+		r, size := utf8.DecodeRune(src[i:])
+		char += UTF16.width(r, size)
+		i += size
+	}
+	return &lsifPos{Line: pos.Line - 1, Character: char}
+}
+
+// lsifHoverResult returns the hover text of def: its signature (or
+// descriptor) as Go code, followed by its doc comment.
+func lsifHoverResult(def *DefInfo) *lsifHover {
+	code := def.Signature
+	if code == "" {
+		code = def.String()
+	}
+	h := &lsifHover{Contents: []lsifMarkedString{{Language: "go", Value: code}}}
+	if def.Doc != "" {
+		h.Contents = append(h.Contents, lsifMarkedString{Value: def.Doc})
+	}
+	return h
+}
+
+// monikerIdentifier returns the identifier of def's moniker:
+// "pkg:Container.Name", or "pkg:Name" or "pkg" without a container or
+// name.
+func monikerIdentifier(def *DefInfo) string {
+	id := def.Package
+	if def.Container != "" {
+		return id + ":" + def.Container + "." + def.Name
+	}
+	if def.Name != "" {
+		return id + ":" + def.Name
+	}
+	return id
+}
+
+// monikerKind returns the kind of def's moniker, given the directories
+// of the packages in the dump.
+func monikerKind(def *DefInfo, dumped map[string]bool) string {
+	if def.Package == "builtin" {
+		return "import"
+	}
+	if def.Kind == KindLocal || def.Kind == KindLabel || (def.Name != "" && !def.Exported) {
+		return "local"
+	}
+	if def.Filename != "" && dumped[filepath.Dir(def.Filename)] {
+		return "export"
+	}
+	return "import"
+}
+
+// fileURI returns the file URI of the absolute filename.
+func fileURI(filename string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filename)}).String()
+}
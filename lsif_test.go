@@ -0,0 +1,202 @@
+package godefinfo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteLSIF(t *testing.T) {
+	t.Setenv("GO111MODULE", "off")
+	root, err := filepath.Abs("testdata/src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := NewResolver(Options{ImportSrc: true, Docs: true}).WriteLSIF(context.Background(), &buf, root, []string{"./..."}); err != nil {
+		t.Fatal(err)
+	}
+
+	type element struct {
+		ID       int
+		Type     string
+		Label    string
+		URI      string
+		Start    *lsifPos
+		Kind     string
+		Scheme   string
+		Ident    string `json:"identifier"`
+		OutV     int
+		InV      int
+		InVs     []int
+		Document int
+		Property string
+	}
+	var elems []*element
+	vertices := map[int]*element{}
+	out := map[int]map[string][]int{} // outV -> edge label -> inVs
+	itemDoc := map[[2]int]int{}       // [item outV, inV] -> document
+	for i, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e element
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("line %d: %s", i+1, err)
+		}
+		if e.ID != i+1 {
+			t.Fatalf("line %d: got id %d, want %d", i+1, e.ID, i+1)
+		}
+		elems = append(elems, &e)
+		switch e.Type {
+		case "vertex":
+			vertices[e.ID] = &e
+		case "edge":
+			// Edges may only refer to vertices that were already
+			// emitted.
+			inVs := e.InVs
+			if e.InV != 0 {
+				inVs = []int{e.InV}
+			}
+			if vertices[e.OutV] == nil || len(inVs) == 0 {
+				t.Fatalf("line %d: bad edge %s", i+1, line)
+			}
+			for _, v := range inVs {
+				if vertices[v] == nil {
+					t.Fatalf("line %d: edge to unknown vertex %d", i+1, v)
+				}
+				if e.Label == "item" {
+					itemDoc[[2]int{e.OutV, v}] = e.Document
+				}
+			}
+			if out[e.OutV] == nil {
+				out[e.OutV] = map[string][]int{}
+			}
+			out[e.OutV][e.Label] = append(out[e.OutV][e.Label], inVs...)
+		default:
+			t.Fatalf("line %d: bad type %q", i+1, e.Type)
+		}
+	}
+	if elems[0].Label != "metaData" || elems[1].Label != "project" {
+		t.Fatalf("got %s and %s first, want metaData and project", elems[0].Label, elems[1].Label)
+	}
+
+	// follow returns the single vertex that v has an edge with the
+	// label to.
+	follow := func(v int, label string) *element {
+		t.Helper()
+		if vs := out[v][label]; len(vs) == 1 {
+			return vertices[vs[0]]
+		}
+		t.Fatalf("vertex %d: got %s edges %v, want 1", v, label, out[v][label])
+		return nil
+	}
+
+	// Each document is in the project, and each range is in exactly
+	// one document and leads to a result set with a hover result and
+	// a moniker.
+	docs := map[string]*element{}
+	rangeDoc := map[int]int{}
+	for _, doc := range out[2]["contains"] {
+		d := vertices[doc]
+		if d.Label != "document" {
+			t.Fatalf("project contains %s", d.Label)
+		}
+		rel, _ := filepath.Rel(root, strings.TrimPrefix(d.URI, "file://"))
+		docs[filepath.ToSlash(rel)] = d
+		for _, rng := range out[doc]["contains"] {
+			if _, ok := rangeDoc[rng]; ok {
+				t.Errorf("range %d is in more than one document", rng)
+			}
+			rangeDoc[rng] = doc
+		}
+	}
+	if len(docs) != 3 || docs["mypkg/a.go"] == nil || docs["mypkg/b.go"] == nil || docs["mypkg/subpkg/c.go"] == nil {
+		t.Fatalf("got documents %v, want a.go, b.go and subpkg/c.go", docs)
+	}
+	for _, e := range elems {
+		if e.Type != "vertex" || e.Label != "range" {
+			continue
+		}
+		if _, ok := rangeDoc[e.ID]; !ok {
+			t.Errorf("range %d is in no document", e.ID)
+		}
+		rs := follow(e.ID, "next")
+		if rs.Label != "resultSet" {
+			t.Fatalf("range %d: next is a %s", e.ID, rs.Label)
+		}
+		if h := follow(rs.ID, "textDocument/hover"); h.Label != "hoverResult" {
+			t.Errorf("result set %d: hover is a %s", rs.ID, h.Label)
+		}
+		if m := follow(rs.ID, "moniker"); m.Label != "moniker" || m.Scheme != "godefinfo" {
+			t.Errorf("result set %d: bad moniker %+v", rs.ID, m)
+		}
+	}
+
+	// rangeAt returns the range at the 0-based line and character in
+	// the document.
+	rangeAt := func(doc string, line, char int) *element {
+		t.Helper()
+		for rng, d := range rangeDoc {
+			if r := vertices[rng]; d == docs[doc].ID && r.Start.Line == line && r.Start.Character == char {
+				return r
+			}
+		}
+		t.Fatalf("no range at %s:%d:%d", doc, line, char)
+		return nil
+	}
+
+	// C0 is referred to in a.go and b.go and declared in c.go.
+	use := rangeAt("mypkg/b.go", 6, 8) // subpkg.C0()
+	rs := follow(use.ID, "next")
+	if m := follow(rs.ID, "moniker"); m.Ident != "mypkg/subpkg:C0" || m.Kind != "export" {
+		t.Errorf("C0 moniker: got %s (%s), want mypkg/subpkg:C0 (export)", m.Ident, m.Kind)
+	}
+	defResult := follow(rs.ID, "textDocument/definition")
+	decl := rangeAt("mypkg/subpkg/c.go", 2, 5)
+	if got := out[defResult.ID]["item"]; len(got) != 1 || got[0] != decl.ID || itemDoc[[2]int{defResult.ID, decl.ID}] != docs["mypkg/subpkg/c.go"].ID {
+		t.Errorf("C0 definition: got items %v, want [%d] in c.go", got, decl.ID)
+	}
+	if follow(decl.ID, "next") != rs {
+		t.Error("C0 declaration and use have different result sets")
+	}
+	refResult := follow(rs.ID, "textDocument/references")
+	refs := out[refResult.ID]["item"]
+	if len(refs) != 3 { // the declaration, and the uses in a.go and b.go
+		t.Errorf("C0 references: got %v, want 3 ranges", refs)
+	}
+
+	// strings.Contains is imported.
+	rs = follow(rangeAt("mypkg/a.go", 21, 9).ID, "next")
+	if m := follow(rs.ID, "moniker"); m.Ident != "strings:Contains" || m.Kind != "import" {
+		t.Errorf("strings.Contains moniker: got %s (%s), want strings:Contains (import)", m.Ident, m.Kind)
+	}
+	if _, ok := out[rs.ID]["textDocument/definition"]; ok {
+		t.Error("strings.Contains has a definition result in the dump")
+	}
+
+	// The method C2 is named by its receiver type.
+	rs = follow(rangeAt("mypkg/a.go", 20, 15).ID, "next")
+	if m := follow(rs.ID, "moniker"); m.Ident != "mypkg/subpkg:C1.C2" {
+		t.Errorf("C2 moniker: got %s, want mypkg/subpkg:C1.C2", m.Ident)
+	}
+}
+
+// TestWriteLSIFAnonymousInterface tests that a package that declares a
+// method of an anonymous interface is dumped, without that method.
+func TestWriteLSIFAnonymousInterface(t *testing.T) {
+	gopath := t.TempDir()
+	withGOPATH(t, gopath)
+	t.Setenv("GO111MODULE", "off")
+	root := filepath.Join(gopath, "src")
+	writeFiles(t, root, map[string]string{
+		"p/p.go": "package p\n\nvar X interface{ M() }\n",
+	})
+	var buf bytes.Buffer
+	if err := NewResolver(Options{ImportSrc: true}).WriteLSIF(context.Background(), &buf, root, []string{"./..."}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(buf.String(), `"label":"range"`); got != 1 { // X
+		t.Errorf("got %d ranges, want 1:\n%s", got, buf.String())
+	}
+}
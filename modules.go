@@ -0,0 +1,300 @@
+package godefinfo
+
+import (
+	"bufio"
+	"bytes"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// modFile is the subset of a go.mod file needed to locate packages.
+type modFile struct {
+	path      string // module path
+	goVersion string
+
+	require map[string]string // module path -> version
+	replace []modReplace
+}
+
+type modReplace struct {
+	oldPath, oldVersion string // oldVersion is empty if the replacement applies to all versions
+	newPath, newVersion string // newVersion is empty if newPath is a directory
+}
+
+// findModule returns the root directory and parsed go.mod file of the
+// module containing dir. If dir is not in a module (or modules are
+// disabled with GO111MODULE=off), mod is nil.
+func findModule(dir string) (root string, mod *modFile) {
+	if dir == "" || os.Getenv("GO111MODULE") == "off" {
+		return "", nil
+	}
+	for dir = absPath(dir); ; {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			return dir, parseModFile(data)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// parseModFile parses the module, go, require and replace directives
+// of a go.mod file. Malformed lines are ignored.
+func parseModFile(data []byte) *modFile {
+	mod := &modFile{require: map[string]string{}}
+	var block string // the directive of the enclosing "verb (" block, if any
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := modFields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			fields = append([]string{block}, fields...)
+		} else if len(fields) == 2 && fields[1] == "(" {
+			block = fields[0]
+			continue
+		}
+
+		switch verb, args := fields[0], fields[1:]; verb {
+		case "module":
+			if len(args) == 1 {
+				mod.path = args[0]
+			}
+		case "go":
+			if len(args) == 1 {
+				mod.goVersion = args[0]
+			}
+		case "require":
+			if len(args) == 2 {
+				mod.require[args[0]] = args[1]
+			}
+		case "replace":
+			var r modReplace
+			switch {
+			case len(args) == 3 && args[1] == "=>":
+				r = modReplace{oldPath: args[0], newPath: args[2]}
+			case len(args) == 4 && args[1] == "=>":
+				r = modReplace{oldPath: args[0], newPath: args[2], newVersion: args[3]}
+			case len(args) == 4 && args[2] == "=>":
+				r = modReplace{oldPath: args[0], oldVersion: args[1], newPath: args[3]}
+			case len(args) == 5 && args[2] == "=>":
+				r = modReplace{oldPath: args[0], oldVersion: args[1], newPath: args[3], newVersion: args[4]}
+			default:
+				continue
+			}
+			mod.replace = append(mod.replace, r)
+		}
+	}
+	return mod
+}
+
+// modFields splits a go.mod line into fields, unquoting quoted
+// strings.
+func modFields(line string) []string {
+	fields := strings.Fields(line)
+	for i, f := range fields {
+		if strings.HasPrefix(f, `"`) || strings.HasPrefix(f, "`") {
+			if u, err := strconv.Unquote(f); err == nil {
+				fields[i] = u
+			}
+		}
+	}
+	return fields
+}
+
+// importPath returns the import path of the package in dir, which
+// must be in the module rooted at root.
+func (mod *modFile) importPath(root, dir string) (string, bool) {
+	rel, err := filepath.Rel(root, absPath(dir))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || mod.path == "" {
+		return "", false
+	}
+	if rel == "." {
+		return mod.path, true
+	}
+	return mod.path + "/" + filepath.ToSlash(rel), true
+}
+
+// packageDir returns the source directory of the package with the
+// given import path when imported from srcDir, with mod as the main
+// module, rooted at root. It looks in GOROOT (and, for imports from
+// the standard library, its vendor directory), the main module (but
+// not in modules nested in it), the vendor directory, replacement
+// directories and the module cache; it never accesses the network.
+func (mod *modFile) packageDir(root, path, srcDir string) (string, bool) {
+	goroot := filepath.Join(build.Default.GOROOT, "src")
+	if isDir(filepath.Join(goroot, path)) {
+		return filepath.Join(goroot, path), true
+	}
+	if strings.HasPrefix(srcDir, goroot+string(filepath.Separator)) {
+		if dir := filepath.Join(goroot, "vendor", filepath.FromSlash(path)); isDir(dir) {
+			return dir, true
+		}
+	}
+
+	// Find the required module that provides the package (the one
+	// with the longest path that is a prefix of the import path).
+	var modPath, version string
+	for p, v := range mod.require {
+		if (path == p || strings.HasPrefix(path, p+"/")) && len(p) > len(modPath) {
+			modPath, version = p, v
+		}
+	}
+
+	if (path == mod.path || strings.HasPrefix(path, mod.path+"/")) && len(mod.path) > len(modPath) {
+		dir := filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(path, mod.path)))
+		if !nestedModule(root, dir) {
+			return dir, isDir(dir)
+		}
+	}
+
+	if vendored(root, path) {
+		return filepath.Join(root, "vendor", filepath.FromSlash(path)), true
+	}
+
+	if modPath == "" {
+		return "", false
+	}
+	rel := filepath.FromSlash(strings.TrimPrefix(path, modPath))
+
+	if r, ok := mod.replacement(modPath, version); ok {
+		if r.newVersion == "" {
+			dir := r.newPath
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(root, dir)
+			}
+			dir = filepath.Join(dir, rel)
+			return dir, isDir(dir)
+		}
+		modPath, version = r.newPath, r.newVersion
+	}
+
+	cache := modCacheDir()
+	if cache == "" {
+		return "", false
+	}
+	encPath, err1 := escapeModPath(modPath)
+	encVersion, err2 := escapeModPath(version)
+	if err1 != nil || err2 != nil {
+		return "", false
+	}
+	dir := filepath.Join(cache, filepath.FromSlash(encPath)+"@"+encVersion, rel)
+	return dir, isDir(dir)
+}
+
+// nestedModule reports whether dir, which is below root, is in another
+// module than the one rooted at root: whether it or one of its parents
+// below root has a go.mod file.
+func nestedModule(root, dir string) bool {
+	for ; len(dir) > len(root); dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// replacement returns the replace directive that applies to the
+// module version, if any. A replacement for the specific version
+// takes precedence over one for all versions.
+func (mod *modFile) replacement(modPath, version string) (modReplace, bool) {
+	var found *modReplace
+	for i, r := range mod.replace {
+		if r.oldPath != modPath {
+			continue
+		}
+		if r.oldVersion == version {
+			return r, true
+		}
+		if r.oldVersion == "" {
+			found = &mod.replace[i]
+		}
+	}
+	if found != nil {
+		return *found, true
+	}
+	return modReplace{}, false
+}
+
+// vendored reports whether the module rooted at root vendors the
+// package, according to its vendor/modules.txt file.
+func vendored(root, path string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(root, "vendor", "modules.txt"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == path {
+			return true
+		}
+	}
+	return false
+}
+
+// modCacheDir returns the module cache directory, like `go env
+// GOMODCACHE` (without running the go command).
+func modCacheDir() string {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	gopath := filepath.SplitList(build.Default.GOPATH)
+	if len(gopath) == 0 || gopath[0] == "" {
+		return ""
+	}
+	return filepath.Join(gopath[0], "pkg", "mod")
+}
+
+// escapeModPath escapes a module path or version for use in the
+// module cache, by replacing each upper-case letter with an
+// exclamation mark followed by the letter's lower-case equivalent.
+func escapeModPath(s string) (string, error) {
+	var buf strings.Builder
+	for _, r := range s {
+		if r == '!' || r >= unicode.MaxASCII {
+			return "", strconv.ErrSyntax
+		}
+		if 'A' <= r && r <= 'Z' {
+			buf.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String(), nil
+}
+
+func isDir(dir string) bool {
+	fi, err := os.Stat(dir)
+	return err == nil && fi.IsDir()
+}
+
+// importPathForDir returns the import path of the package in dir,
+// using the enclosing module's go.mod file if there is one and GOPATH
+// otherwise. It returns "" if the import path can't be determined.
+func importPathForDir(dir string) string {
+	if root, mod := findModule(dir); mod != nil {
+		path, _ := mod.importPath(root, dir)
+		return path
+	}
+	bpkg, err := build.ImportDir(dir, build.FindOnly|build.AllowBinary)
+	if err != nil || bpkg.ImportPath == "." {
+		return ""
+	}
+	return bpkg.ImportPath
+}
@@ -0,0 +1,93 @@
+package godefinfo
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// overlay maps cleaned absolute filenames to file contents that are
+// used instead of the contents on disk.
+type overlay map[string][]byte
+
+func newOverlay(files map[string][]byte) overlay {
+	if len(files) == 0 {
+		return nil
+	}
+	o := make(overlay, len(files))
+	for name, src := range files {
+		o[absPath(name)] = src
+	}
+	return o
+}
+
+// get returns the overlaid contents of the named file, if any.
+func (o overlay) get(filename string) ([]byte, bool) {
+	if o == nil {
+		return nil, false
+	}
+	src, ok := o[absPath(filename)]
+	return src, ok
+}
+
+// namesIn returns the sorted base names of the overlaid files in dir.
+func (o overlay) namesIn(dir string) []string {
+	var names []string
+	dir = absPath(dir)
+	for filename := range o {
+		if filepath.Dir(filename) == dir {
+			names = append(names, filepath.Base(filename))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func absPath(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filepath.Clean(filename)
+}
+
+func hashContents(src []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(src))
+}
+
+// ParseOverlayArchive reads an archive of file contents in the format
+// used by the -modified flag of other Go tools: for each file, its
+// name on one line, its size in bytes in decimal on the next line,
+// and then its contents.
+func ParseOverlayArchive(r io.Reader) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	br := bufio.NewReader(r)
+	for {
+		filename, err := br.ReadString('\n')
+		if err == io.EOF && filename == "" {
+			return files, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading overlay archive file name: %s", err)
+		}
+		filename = strings.TrimSuffix(filename, "\n")
+
+		sizeStr, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("reading overlay archive size of %s: %s", filename, err)
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(sizeStr))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("bad overlay archive size for %s: %q", filename, sizeStr)
+		}
+
+		src := make([]byte, size)
+		if _, err := io.ReadFull(br, src); err != nil {
+			return nil, fmt.Errorf("reading overlay archive contents of %s: %s", filename, err)
+		}
+		files[filename] = src
+	}
+}
@@ -0,0 +1,205 @@
+package godefinfo
+
+import (
+	"context"
+	"go/ast"
+	"go/build"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A Package is a parsed and type-checked package, from which the
+// definitions of many identifiers in its files can be resolved without
+// checking it again.
+type Package struct {
+	r     *Resolver
+	q     Query   // the query that Check was called with
+	ov    overlay // q.Overlay
+	pkg   *types.Package
+	files []*ast.File // files[0] is q.Filename
+	info  *types.Info
+
+	mu      sync.Mutex
+	sources map[string][]byte // contents of files that definitions are in, by absolute name
+}
+
+// Check parses and type-checks the package that contains q.Filename
+// (q.Offset is ignored). The package consists of q.Filename and the
+// other files in its directory that belong to the same package;
+// *_test.go files are only included if q.Filename is a test file.
+func (r *Resolver) Check(ctx context.Context, q Query) (*Package, error) {
+	ov := newOverlay(q.Overlay)
+	src := q.Src
+	if src == nil {
+		src, _ = ov.get(q.Filename)
+	}
+	ctxt := r.buildContext(ov)
+	pkgFiles, err := r.parsePackage(ctxt, q.Filename, src, ov)
+	if err != nil {
+		return nil, err
+	}
+
+	var importPath string
+	if q.Filename != "" {
+		importPath = importPathForDir(filepath.Dir(q.Filename))
+	}
+
+	if r.opts.GoBuild && q.Filename != "" {
+		t1 := time.Now()
+		if err := r.listExports(ctx, filepath.Dir(q.Filename)); err != nil {
+			r.dlog.Println("go list:", err)
+		}
+		r.dlog.Println("go list took", time.Since(t1))
+	}
+
+	if importPath == "" {
+		importPath = pkgFiles[0].Name.Name
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	pkg, info, err := r.checkFiles(ctxt, ov, filepath.Dir(q.Filename), importPath, pkgFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Package{
+		r:     r,
+		q:     q,
+		ov:    ov,
+		pkg:   pkg,
+		files: pkgFiles,
+		info:  info,
+	}, nil
+}
+
+// checkFiles type-checks files as the package with the given import
+// path in dir. Type errors are only returned in strict mode.
+func (r *Resolver) checkFiles(ctxt *build.Context, ov overlay, dir, importPath string, files []*ast.File) (*types.Package, *types.Info, error) {
+	conf := types.Config{
+		Importer:                 r.importer(ctxt, ov, dir),
+		Sizes:                    types.SizesFor("gc", ctxt.GOARCH),
+		FakeImportC:              true,
+		DisableUnusedImportCheck: true,
+		Error:                    func(error) {},
+	}
+	info := &types.Info{
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+		Implicits:  map[ast.Node]types.Object{},
+		Types:      map[ast.Expr]types.TypeAndValue{},
+	}
+	pkg, err := conf.Check(importPath, r.fset, files, info)
+	if err != nil && !ignoreError(err) {
+		if r.opts.Strict {
+			return nil, nil, wrapError(ErrTypeCheck, "type checking failed", err)
+		}
+		r.dlog.Println(err)
+	}
+	return pkg, info, nil
+}
+
+// Resolve finds the definition of the identifier at the 1-based byte
+// offset in the named file, which must be one of the package's files.
+// If filename is empty, it refers to the file that Check was called
+// with.
+func (p *Package) Resolve(filename string, offset int) (*DefInfo, error) {
+	file := p.file(filename)
+	if file == nil {
+		return nil, errorf(ErrNoIdentifier, "%s is not in package %s", filename, p.pkg.Path())
+	}
+	return p.resolveFile(file, offset)
+}
+
+// resolveFile is like Resolve, but takes the file instead of its name.
+func (p *Package) resolveFile(file *ast.File, offset int) (*DefInfo, error) {
+	def, err := p.resolve(file, offset)
+	if err != nil {
+		return nil, err
+	}
+	p.complete(def)
+	return def, nil
+}
+
+// complete sets the fields of def that are derived from its object:
+// its description, position and (if requested) doc comment.
+func (p *Package) complete(def *DefInfo) {
+	def.describe()
+	if def.obj != nil && def.obj.Pos().IsValid() {
+		p.setPosition(def)
+		if p.r.opts.Docs && def.Offset != 0 {
+			def.Doc = p.doc(def)
+		}
+	}
+}
+
+// pathAt returns the path of AST nodes that enclose the 1-based byte
+// offset in file, from the innermost outwards.
+func (p *Package) pathAt(file *ast.File, offset int) ([]ast.Node, error) {
+	tf := p.r.fset.File(file.Pos())
+	if tf == nil {
+		// No package clause, so nothing was parsed.
+		return nil, errorf(ErrNoIdentifier, "no identifier found")
+	}
+	if offset < 1 || offset > tf.Size()+1 {
+		return nil, errorf(ErrNoIdentifier, "offset %d is out of range", offset)
+	}
+	pos := tf.Pos(offset - 1)
+	path, _ := pathEnclosingInterval(file, pos, pos)
+	return path, nil
+}
+
+// file returns the package's file with the given name, or nil if there
+// is none.
+func (p *Package) file(filename string) *ast.File {
+	if filename == "" || filename == p.q.Filename {
+		return p.files[0]
+	}
+	filename = absPath(filename)
+	for _, f := range p.files {
+		if tf := p.r.fset.File(f.Pos()); tf != nil && absPath(tf.Name()) == filename {
+			return f
+		}
+	}
+	return nil
+}
+
+// source returns the contents of the named file (which must be
+// absolute), from the query or its overlay if the file is overlaid.
+func (p *Package) source(filename string) ([]byte, error) {
+	if src := p.overlaid(filename); src != nil {
+		return src, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if src, ok := p.sources[filename]; ok {
+		return src, nil
+	}
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if p.sources == nil {
+		p.sources = map[string][]byte{}
+	}
+	p.sources[filename] = src
+	return src, nil
+}
+
+// overlaid returns the contents of the named file (which must be
+// absolute) from the query or its overlay, or nil if the file's
+// contents on disk are used.
+func (p *Package) overlaid(filename string) []byte {
+	if p.q.Src != nil && filename == absPath(p.q.Filename) {
+		return p.q.Src
+	}
+	src, _ := p.ov.get(filename)
+	return src
+}
@@ -0,0 +1,234 @@
+package godefinfo
+
+import (
+	"bytes"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileCache holds the parsed ASTs of files read from disk, so that a
+// long-lived Resolver only reparses files that changed.
+type fileCache struct {
+	mu    sync.Mutex
+	files map[string]*parsedFile
+	stats ParseStats
+}
+
+// ParseStats counts the files that a Resolver has parsed.
+type ParseStats struct {
+	// Files is the number of times a file was parsed, including
+	// reparses.
+	Files int
+
+	// Reparses is the number of times a file was parsed again because
+	// it (or its contents in a query) changed. The Resolver's file set
+	// keeps the position information of every version of a file, so a
+	// long-running program should replace the Resolver with a new one
+	// once it has reparsed many files.
+	Reparses int
+}
+
+type parsedFile struct {
+	// For files read from disk, modTime and size identify the version
+	// of the file that was parsed. For other files, hash is the
+	// SHA-256 hash of the contents that were parsed.
+	modTime time.Time
+	size    int64
+	hash    string
+
+	file *ast.File
+	err  error
+}
+
+func newFileCache() *fileCache {
+	return &fileCache{files: map[string]*parsedFile{}}
+}
+
+func (c *fileCache) get(filename string) *parsedFile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.files[filename]
+}
+
+func (c *fileCache) add(filename string, pf *parsedFile) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Files++
+	if c.files[filename] != nil {
+		c.stats.Reparses++
+	}
+	c.files[filename] = pf
+}
+
+// ParseStats returns the counts of the files that r has parsed.
+func (r *Resolver) ParseStats() ParseStats {
+	r.files.mu.Lock()
+	defer r.files.mu.Unlock()
+	return r.files.stats
+}
+
+// parsed reports whether pos is in the current parse of a source
+// file, rather than in a file made up by the export data importer
+// (which shares r's file set).
+func (r *Resolver) parsed(pos token.Pos) bool {
+	tf := r.fset.File(pos)
+	if tf == nil {
+		return false
+	}
+	pf := r.files.get(tf.Name())
+	return pf != nil && pf.file != nil && pf.file.Package.IsValid() && r.fset.File(pf.file.Pos()) == tf
+}
+
+// parseFile parses the named file. If src is nil, the file is read
+// from disk. Results are cached until the file's modification time
+// or size (or, if src is given, its contents) change.
+func (r *Resolver) parseFile(filename string, src []byte) (*ast.File, error) {
+	if src != nil {
+		hash := hashContents(src)
+		if pf := r.files.get(filename); pf != nil && pf.hash == hash {
+			return pf.file, pf.err
+		}
+		f, err := parser.ParseFile(r.fset, filename, src, parser.ParseComments)
+		r.files.add(filename, &parsedFile{hash: hash, file: f, err: err})
+		return f, err
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, wrapError(ErrIO, "reading source file", err)
+	}
+	if pf := r.files.get(filename); pf != nil && pf.hash == "" && pf.modTime.Equal(fi.ModTime()) && pf.size == fi.Size() {
+		return pf.file, pf.err
+	}
+
+	src, err = ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, wrapError(ErrIO, "reading source file", err)
+	}
+	f, err := parser.ParseFile(r.fset, filename, src, parser.ParseComments)
+	r.files.add(filename, &parsedFile{modTime: fi.ModTime(), size: fi.Size(), file: f, err: err})
+	return f, err
+}
+
+// listDir returns the sorted names of the files in dir, including
+// files that only exist in the overlay.
+func listDir(dir string, ov overlay) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && len(ov.namesIn(dir)) == 0 {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range entries {
+		if !fi.IsDir() {
+			names = append(names, fi.Name())
+		}
+	}
+	for _, name := range ov.namesIn(dir) {
+		if i := sort.SearchStrings(names, name); i == len(names) || names[i] != name {
+			names = append(names, name)
+			sort.Strings(names)
+		}
+	}
+	return names, nil
+}
+
+// parseDir parses the files in dir whose names are accepted by filter,
+// reading overlaid files from ov, and returns them grouped by package
+// name. Files with parse errors are included if they could be
+// partially parsed, unless they have no package clause (and so belong
+// to no package); the first error is returned.
+func (r *Resolver) parseDir(dir string, filter func(name string) bool, ov overlay) (map[string][]*ast.File, error) {
+	names, err := listDir(dir, ov)
+	if err != nil {
+		return nil, err
+	}
+
+	var firstErr error
+	pkgs := map[string][]*ast.File{}
+	for _, name := range names {
+		if !filter(name) {
+			continue
+		}
+		filename := filepath.Join(dir, name)
+		src, _ := ov.get(filename)
+		f, err := r.parseFile(filename, src)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if f != nil && f.Package.IsValid() {
+			pkgs[f.Name.Name] = append(pkgs[f.Name.Name], f)
+		}
+	}
+	return pkgs, firstErr
+}
+
+// buildContext returns the go/build context used to select files:
+// build.Default, adjusted by r's options, that reads overlaid files
+// from ov.
+func (r *Resolver) buildContext(ov overlay) *build.Context {
+	ctxt := build.Default
+	if r.opts.GOOS != "" && r.opts.GOOS != ctxt.GOOS {
+		ctxt.GOOS = r.opts.GOOS
+		ctxt.CgoEnabled = false // as when cross-compiling
+	}
+	if r.opts.GOARCH != "" && r.opts.GOARCH != ctxt.GOARCH {
+		ctxt.GOARCH = r.opts.GOARCH
+		ctxt.CgoEnabled = false
+		ctxt.ToolTags = crossToolTags(ctxt.ToolTags, build.Default.GOARCH, ctxt.GOARCH)
+	}
+	if len(r.opts.BuildTags) > 0 {
+		ctxt.BuildTags = append(ctxt.BuildTags[:len(ctxt.BuildTags):len(ctxt.BuildTags)], r.opts.BuildTags...)
+	}
+	if ov != nil {
+		ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+			if src, ok := ov.get(path); ok {
+				return ioutil.NopCloser(bytes.NewReader(src)), nil
+			}
+			return os.Open(path)
+		}
+	}
+	return &ctxt
+}
+
+// regabiArchs are the architectures that use the register-based ABI,
+// whose GOEXPERIMENT build tags are set by default.
+var regabiArchs = map[string]bool{"amd64": true, "arm64": true, "loong64": true, "ppc64": true, "ppc64le": true, "riscv64": true}
+
+// crossToolTags adapts the tool tags of the host (whose architecture
+// is hostArch) to goarch: the host's architecture feature level tags
+// (eg, "amd64.v1") are dropped, as are the register ABI experiments if
+// goarch doesn't use them. Without that, the standard library's
+// architecture-specific files are mis-selected (eg, internal/abi's
+// register counts are left undefined for 386).
+func crossToolTags(tags []string, hostArch, goarch string) []string {
+	var cross []string
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, hostArch+".") || strings.HasPrefix(tag, "goexperiment.regabi") && !regabiArchs[goarch] {
+			continue
+		}
+		cross = append(cross, tag)
+	}
+	return cross
+}
+
+// matchFile reports whether the named file in dir is a Go source file
+// of its package in ctxt, taking build constraints and GOOS/GOARCH
+// file name suffixes into account. Test files are only included if
+// tests is set.
+func matchFile(ctxt *build.Context, dir, name string, tests bool) bool {
+	if !strings.HasSuffix(name, ".go") || (!tests && strings.HasSuffix(name, "_test.go")) {
+		return false
+	}
+	ok, err := ctxt.MatchFile(dir, name)
+	return ok && err == nil
+}
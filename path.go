@@ -4,7 +4,7 @@
 // to eliminate external (non-stdlib) dependencies.
 ////////////////////////////////////////////////////////////////////////////////////////
 
-package main
+package godefinfo
 
 const goRepoPath = 1
 
@@ -0,0 +1,89 @@
+package godefinfo
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// ColumnEncoding says what unit columns are counted in.
+type ColumnEncoding string
+
+const (
+	UTF8  ColumnEncoding = "utf8"  // bytes (as in go/token)
+	UTF16 ColumnEncoding = "utf16" // UTF-16 code units (as in LSP)
+	Rune  ColumnEncoding = "rune"  // Unicode code points
+)
+
+// ParseColumnEncoding returns the ColumnEncoding named s.
+func ParseColumnEncoding(s string) (ColumnEncoding, error) {
+	switch enc := ColumnEncoding(s); enc {
+	case UTF8, UTF16, Rune:
+		return enc, nil
+	}
+	return "", fmt.Errorf("unknown column encoding %q (want utf8, utf16 or rune)", s)
+}
+
+// width returns the number of units of enc that encode r, which is
+// size bytes long in UTF-8.
+func (enc ColumnEncoding) width(r rune, size int) int {
+	switch enc {
+	case UTF16:
+		if r >= 0x10000 {
+			return 2
+		}
+		return 1
+	case Rune:
+		return 1
+	}
+	return size
+}
+
+// OffsetOf returns the 1-based byte offset (as in Query.Offset) of the
+// 1-based line and column in src, where the column is counted in enc
+// units. Lines end at "\n" or "\r\n"; the column just past the end of
+// a line refers to its end.
+func OffsetOf(src []byte, line, column int, enc ColumnEncoding) (int, error) {
+	if line < 1 || column < 1 {
+		return 0, fmt.Errorf("invalid position %d:%d", line, column)
+	}
+	if line > bytes.Count(src, []byte("\n"))+1 {
+		return 0, fmt.Errorf("line %d is past the end of the file", line)
+	}
+	offset := lineOffset(src, line)
+	for col := 1; col < column; {
+		if offset >= len(src) || src[offset] == '\n' || (src[offset] == '\r' && offset+1 < len(src) && src[offset+1] == '\n') {
+			return 0, fmt.Errorf("column %d is past the end of line %d", column, line)
+		}
+		r, size := utf8.DecodeRune(src[offset:])
+		col += enc.width(r, size)
+		if col > column {
+			return 0, fmt.Errorf("column %d of line %d is inside a character", column, line)
+		}
+		offset += size
+	}
+	return offset + 1, nil
+}
+
+// ColumnOf returns the 1-based line and column, counted in enc units,
+// of the 1-based byte offset (as in Query.Offset) in src.
+func ColumnOf(src []byte, offset int, enc ColumnEncoding) (line, column int) {
+	offset-- // to 0-based
+	if offset > len(src) {
+		offset = len(src)
+	}
+	line, column = 1, 1
+	start := 0
+	for i := 0; i < offset; i++ {
+		if src[i] == '\n' {
+			line++
+			start = i + 1
+		}
+	}
+	for i := start; i < offset; {
+		r, size := utf8.DecodeRune(src[i:])
+		column += enc.width(r, size)
+		i += size
+	}
+	return line, column
+}
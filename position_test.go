@@ -0,0 +1,65 @@
+package godefinfo
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestOffsetOf(t *testing.T) {
+	// é is 2 bytes in UTF-8 and 1 UTF-16 unit; 𝒳 is 4 bytes in UTF-8
+	// and 2 UTF-16 units.
+	const src = "package p\r\n\r\nvar é, 𝒳 = 1, 2\r\n\r\nvar _ = é + 𝒳 // 𝒳\r\n"
+	xOffset := strings.LastIndex(src, "+ 𝒳") + len("+ ") + 1
+
+	tests := []struct {
+		enc    ColumnEncoding
+		column int
+	}{
+		{UTF8, len("var _ = é + ") + 1},
+		{UTF16, len("var _ = e + ") + 1},
+		{Rune, len("var _ = e + ") + 1},
+	}
+	for _, test := range tests {
+		offset, err := OffsetOf([]byte(src), 5, test.column, test.enc)
+		if err != nil {
+			t.Errorf("%s: %s", test.enc, err)
+			continue
+		}
+		if offset != xOffset {
+			t.Errorf("%s: 5:%d: got offset %d, want %d", test.enc, test.column, offset, xOffset)
+		}
+		if line, column := ColumnOf([]byte(src), offset, test.enc); line != 5 || column != test.column {
+			t.Errorf("%s: offset %d: got %d:%d, want 5:%d", test.enc, offset, line, column, test.column)
+		}
+
+		def, err := Resolve(context.Background(), Query{Filename: "/tmp/p/p.go", Src: []byte(src), Offset: offset})
+		if err != nil {
+			t.Errorf("%s: %s", test.enc, err)
+			continue
+		}
+		if got, want := def.String(), "p 𝒳"; got != want {
+			t.Errorf("%s: got %q, want %q", test.enc, got, want)
+		}
+	}
+
+	// The end of a CRLF-terminated line is before the "\r".
+	if offset, err := OffsetOf([]byte(src), 1, len("package p")+1, UTF8); err != nil || offset != len("package p")+1 {
+		t.Errorf("end of line: got offset %d (error: %v), want %d", offset, err, len("package p")+1)
+	}
+
+	bad := []struct{ line, column int }{
+		{0, 1},
+		{1, 0},
+		{1, len("package p") + 2}, // past the end of the line (the "\r" is not a column)
+		{7, 1},                    // past the end of the file
+	}
+	for _, b := range bad {
+		if offset, err := OffsetOf([]byte(src), b.line, b.column, UTF8); err == nil {
+			t.Errorf("%d:%d: got offset %d, want error", b.line, b.column, offset)
+		}
+	}
+	if offset, err := OffsetOf([]byte(src), 3, len("var é, ")+2, UTF8); err == nil {
+		t.Errorf("inside a character: got offset %d, want error", offset)
+	}
+}
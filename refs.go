@@ -0,0 +1,294 @@
+package godefinfo
+
+import (
+	"context"
+	"go/build"
+	"go/types"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A Reference is an identifier that refers to a definition.
+type Reference struct {
+	Filename string
+
+	// Line, Column and EndColumn are 1-based; columns are counted in
+	// bytes.
+	Line, Column, EndColumn int
+
+	// Offset and EndOffset delimit the identifier as 1-based byte
+	// offsets (EndOffset is exclusive), like DefInfo's.
+	Offset, EndOffset int
+}
+
+// References finds the identifiers that refer to the definition of
+// the identifier at the 1-based byte offset in the named file (see
+// Resolve). Every package in root and its subdirectories (except
+// testdata, vendor and hidden directories) is type-checked, along
+// with its tests, and searched; if root is empty, it is the root of
+// the module containing the file, or in GOPATH mode the src directory
+// of its GOPATH workspace. Unexported definitions can only be
+// referred to from their own package, so only its directory is
+// searched for them.
+//
+// Uses of promoted fields and methods (selected through an embedded
+// field) refer to the embedded type's definition. The definition's
+// own declaration is not a reference. References are returned sorted
+// by filename and offset.
+func (p *Package) References(ctx context.Context, filename string, offset int, root string) ([]*Reference, error) {
+	file := p.file(filename)
+	if file == nil {
+		return nil, errorf(ErrNoIdentifier, "%s is not in package %s", filename, p.pkg.Path())
+	}
+	def, err := p.resolveFile(file, offset)
+	if err != nil {
+		return nil, err
+	}
+	if def.obj == nil {
+		return nil, errorf(ErrUnsupported, "finding references to %s is not supported", def)
+	}
+	if _, ok := def.obj.(*types.PkgName); ok {
+		return nil, errorf(ErrUnsupported, "finding references to package %s is not supported", def.Package)
+	}
+
+	ov := p.searchOverlay()
+	ctxt := p.r.buildContext(ov)
+
+	var dirs []string
+	if def.obj.Pkg() != nil && !def.obj.Exported() {
+		dirs = []string{filepath.Dir(absPath(p.r.position(def.obj.Pos()).Filename))}
+	} else if dirs, err = p.searchDirs(ctxt, ov, root); err != nil {
+		return nil, err
+	}
+
+	k := newKeyer(p.r)
+	target := k.key(def.obj)
+	seen := map[Reference]bool{}
+	var refs []*Reference
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pkgs, err := p.r.checkDir(ctxt, dir, ov)
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range pkgs {
+			for id, obj := range pkg.info.Uses {
+				if !k.key(originObject(obj)).matches(target) {
+					continue
+				}
+				pos := p.r.fset.Position(id.Pos())
+				ref := Reference{
+					Filename:  absPath(pos.Filename),
+					Line:      pos.Line,
+					Column:    pos.Column,
+					EndColumn: pos.Column + len(id.Name),
+					Offset:    pos.Offset + 1,
+					EndOffset: pos.Offset + 1 + len(id.Name),
+				}
+				if !seen[ref] {
+					seen[ref] = true
+					refs = append(refs, &ref)
+				}
+			}
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Filename != refs[j].Filename {
+			return refs[i].Filename < refs[j].Filename
+		}
+		return refs[i].Offset < refs[j].Offset
+	})
+	return refs, nil
+}
+
+// objKey identifies an object independently of the type check that
+// created it, so that a definition can be matched with uses in
+// packages that were type-checked separately (and may have imported
+// the definition's package from source or from export data). Export
+// data only records accurate line numbers (every object is at column
+// 1), so the column of an object from export data is unknown and
+// recorded as 0.
+type objKey struct {
+	filename     string
+	line, column int
+	name         string
+}
+
+// matches reports whether k and k2 identify the same object. Their
+// columns are only compared if both are known.
+func (k objKey) matches(k2 objKey) bool {
+	if k.column != 0 && k2.column != 0 && k.column != k2.column {
+		return false
+	}
+	k.column, k2.column = 0, 0
+	return k == k2
+}
+
+// keyer computes objKeys, remembering the absolute forms of the
+// filenames it has seen.
+type keyer struct {
+	r   *Resolver
+	abs map[string]string
+}
+
+func newKeyer(r *Resolver) *keyer {
+	return &keyer{r: r, abs: map[string]string{}}
+}
+
+func (k *keyer) key(obj types.Object) objKey {
+	if obj.Pkg() == nil {
+		// Predeclared objects are unique.
+		return objKey{name: obj.Name()}
+	}
+	pos := k.r.position(obj.Pos())
+	abs, ok := k.abs[pos.Filename]
+	if !ok {
+		abs = absPath(pos.Filename)
+		k.abs[pos.Filename] = abs
+	}
+	column := pos.Column
+	if !k.r.parsed(obj.Pos()) {
+		column = 0
+	}
+	return objKey{abs, pos.Line, column, obj.Name()}
+}
+
+// searchOverlay returns the overlay to use when searching packages
+// other than p: p's overlay, plus the source of the query that p was
+// checked with, so that p's package is searched as the caller sees
+// it.
+func (p *Package) searchOverlay() overlay {
+	if p.q.Src == nil {
+		return p.ov
+	}
+	ov := overlay{}
+	for name, src := range p.ov {
+		ov[name] = src
+	}
+	ov[absPath(p.q.Filename)] = p.q.Src
+	return ov
+}
+
+// searchDirs returns the package directories in root, which defaults
+// to defaultRoot of the query's directory.
+func (p *Package) searchDirs(ctxt *build.Context, ov overlay, root string) ([]string, error) {
+	if root == "" {
+		root = defaultRoot(ctxt, filepath.Dir(absPath(p.q.Filename)))
+	}
+	dirs, err := packageDirs(ctxt, absPath(root), ov)
+	if err != nil {
+		return nil, wrapError(ErrIO, "listing packages", err)
+	}
+	return dirs, nil
+}
+
+// defaultRoot returns the directory whose packages are searched for
+// references to definitions in dir: the root of the enclosing module,
+// or the src directory of the enclosing GOPATH workspace, or else dir
+// itself.
+func defaultRoot(ctxt *build.Context, dir string) string {
+	if root, mod := findModule(dir); mod != nil {
+		return root
+	}
+	for _, gopath := range filepath.SplitList(ctxt.GOPATH) {
+		src := filepath.Join(absPath(gopath), "src")
+		if rel, err := filepath.Rel(src, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return src
+		}
+	}
+	return dir
+}
+
+// packageDirs returns the directories in root and its subdirectories
+// that contain Go files, skipping testdata and vendor directories and
+// directories whose names begin with "." or "_", as the go command
+// does. If root is a symbolic link, its target is searched, but the
+// directories are named relative to root.
+func packageDirs(ctxt *build.Context, root string, ov overlay) ([]string, error) {
+	target, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	err = filepath.WalkDir(target, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != target {
+			if name := d.Name(); name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+		}
+		rel, err := filepath.Rel(target, path)
+		if err != nil {
+			return err
+		}
+		dir := filepath.Join(root, rel)
+		names, err := listDir(dir, ov)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if matchFile(ctxt, dir, name, true) {
+				dirs = append(dirs, dir)
+				break
+			}
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// checkDir type-checks the packages in dir, including test files: the
+// package itself (with its in-package tests) and its external test
+// package, if any. Parse and type errors are only returned in strict
+// mode.
+func (r *Resolver) checkDir(ctxt *build.Context, dir string, ov overlay) ([]*Package, error) {
+	files, err := r.parseDir(dir, func(name string) bool {
+		return matchFile(ctxt, dir, name, true)
+	}, ov)
+	if err != nil {
+		if r.opts.Strict {
+			return nil, wrapError(ErrParse, "parsing package", err)
+		}
+		r.dlog.Println(err)
+	}
+
+	importPath := importPathForDir(dir)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pkgs []*Package
+	for _, name := range names {
+		path := importPath
+		if path == "" {
+			path = name
+		}
+		if base := strings.TrimSuffix(name, "_test"); base != name && files[base] != nil {
+			path += "_test"
+		}
+		pkg, info, err := r.checkFiles(ctxt, ov, dir, path, files[name])
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, &Package{
+			r:     r,
+			q:     Query{Filename: r.fset.File(files[name][0].Pos()).Name()},
+			ov:    ov,
+			pkg:   pkg,
+			files: files[name],
+			info:  info,
+		})
+	}
+	return pkgs, nil
+}
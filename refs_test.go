@@ -0,0 +1,117 @@
+package godefinfo
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReferences(t *testing.T) {
+	const depSrc = `package dep
+
+type Inner struct{ F int }
+
+func (Inner) M() {}
+
+func (i *Inner) m() { i.M() }
+
+type I interface{ M() }
+
+func Shadow(x int) int { if x := x + 1; x > 0 { return x }; return x }
+
+func Wrapped(
+x int) int { if x := x + 1; x > 0 { return x }; return x }
+`
+	gopath := t.TempDir()
+	withGOPATH(t, gopath)
+	t.Setenv("GO111MODULE", "off")
+	src := filepath.Join(gopath, "src")
+	writeFiles(t, src, map[string]string{
+		"dep/dep.go": depSrc,
+		"dep/dep_test.go": `package dep_test
+
+import "dep"
+
+var _ = dep.Inner{F: 1}.F
+`,
+		"top/top.go": `package top
+
+import "dep"
+
+type Outer struct {
+	dep.Inner
+	*Ptr
+}
+
+type Ptr struct{ Outer2 }
+
+type Outer2 struct{ dep.Inner }
+
+func F(o Outer, i dep.I) {
+	o.M()
+	o.Outer2.M()
+	i.M()
+	_ = o.F
+	_ = o.Inner.F
+	_ = dep.Inner.M
+}
+`,
+		"testdata/x/x.go": "package x\n\nimport \"dep\"\n\nvar _ = dep.Inner{}.M\n",
+	})
+	depFile := filepath.Join(src, "dep", "dep.go")
+	p, err := NewResolver(Options{Strict: true, ImportSrc: true}).Check(context.Background(), Query{Filename: depFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		at   string // the identifier ends the first occurrence of at
+		want []string
+	}{
+		{"func (Inner) M", []string{"dep/dep.go:7:25", "top/top.go:15:4", "top/top.go:16:11", "top/top.go:20:16"}},
+		{"struct{ F", []string{"dep/dep_test.go:5:19", "dep/dep_test.go:5:25", "top/top.go:18:8", "top/top.go:19:14"}},
+		{"type Inner", []string{"dep/dep.go:5:7", "dep/dep.go:7:10", "dep/dep_test.go:5:13", "top/top.go:6:6", "top/top.go:12:25", "top/top.go:20:10"}},
+		{"interface{ M", []string{"top/top.go:17:4"}},
+		{"func (i *Inner) m", nil}, // unexported and unused
+		{"(i", []string{"dep/dep.go:7:23"}},
+		{"Shadow(x", []string{"dep/dep.go:11:34", "dep/dep.go:11:68"}}, // not the x declared on the same line
+		{"if x", []string{"dep/dep.go:11:41", "dep/dep.go:11:56"}},
+		{"Wrapped(\nx", []string{"dep/dep.go:14:22", "dep/dep.go:14:56"}}, // at column 1
+	}
+	for _, test := range tests {
+		offset := strings.Index(depSrc, test.at) + len(test.at)
+		refs, err := p.References(context.Background(), "", offset, "")
+		if err != nil {
+			t.Errorf("%q: %s", test.at, err)
+			continue
+		}
+		var got []string
+		for _, ref := range refs {
+			rel, _ := filepath.Rel(src, ref.Filename)
+			got = append(got, fmt.Sprintf("%s:%d:%d", filepath.ToSlash(rel), ref.Line, ref.Column))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%q: got %v, want %v", test.at, got, test.want)
+		}
+	}
+
+	// Only the root's packages are searched.
+	offset := strings.Index(depSrc, "Inner") + 1
+	refs, err := p.References(context.Background(), "", offset, filepath.Join(src, "dep"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range refs {
+		if filepath.Dir(ref.Filename) != filepath.Join(src, "dep") {
+			t.Errorf("with root dep: got reference in %s", ref.Filename)
+		}
+	}
+	if len(refs) != 3 {
+		t.Errorf("with root dep: got %d references, want 3", len(refs))
+	}
+
+	if _, err := p.References(context.Background(), "", strings.Index(depSrc, "package")+1, ""); err == nil {
+		t.Error("references to keyword: got no error")
+	}
+}
@@ -0,0 +1,169 @@
+package godefinfo
+
+import (
+	"bytes"
+	"context"
+	"go/ast"
+	"go/types"
+)
+
+// A Tag is a package-level definition, or a method or field of a
+// package-level type, for a tags file such as ctags or etags
+// generate.
+type Tag struct {
+	Name string
+
+	// Container is the type that a method or field belongs to, as in
+	// DefInfo (so a promoted method is only tagged in the type that
+	// declares it), and ContainerKind is its kind: "struct",
+	// "interface" or "type".
+	Container     string
+	ContainerKind string
+
+	Kind Kind
+
+	// TypeKind refines KindType: "struct", "interface", "alias" or
+	// "type".
+	TypeKind string
+
+	// Signature is the parameters and results of a func or method,
+	// eg "(req *Request) (*Response, error)".
+	Signature string
+
+	// Filename, Line, Column and Offset are the position of the
+	// declaring identifier, as in DefInfo. Text is the line it is
+	// on, without the line terminator.
+	Filename             string
+	Line, Column, Offset int
+	Text                 string
+}
+
+// Tags type-checks every package (with its tests) in root and its
+// subdirectories, skipping the same directories as References, and
+// returns a tag for each definition that its files declare, in file
+// order. Locals, labels, type parameters and the methods and fields of
+// anonymous interfaces and structs are not tagged.
+func (r *Resolver) Tags(ctx context.Context, root string) ([]*Tag, error) {
+	ctxt := r.buildContext(nil)
+	dirs, err := packageDirs(ctxt, absPath(root), nil)
+	if err != nil {
+		return nil, wrapError(ErrIO, "listing packages", err)
+	}
+
+	var tags []*Tag
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pkgs, err := r.checkDir(ctxt, dir, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pkgs {
+			for _, f := range p.files {
+				tags = append(tags, p.fileTags(f)...)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// fileTags returns the tags of the definitions declared in f.
+func (p *Package) fileTags(f *ast.File) []*Tag {
+	tf := p.r.fset.File(f.Pos())
+	if tf == nil {
+		return nil
+	}
+	base := tf.Base()
+	src, err := p.source(absPath(tf.Name()))
+	if err != nil {
+		p.r.dlog.Println("reading source file:", err)
+		return nil
+	}
+
+	var tags []*Tag
+	ast.Inspect(f, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || id.Name == "_" {
+			return true
+		}
+		obj := p.info.Defs[id]
+		switch obj.(type) {
+		case nil, *types.PkgName, *types.Label:
+			return true
+		}
+		if isLocal(obj) {
+			return true
+		}
+		if tn, ok := obj.(*types.TypeName); ok {
+			if _, ok := tn.Type().(*types.TypeParam); ok {
+				return true
+			}
+		}
+
+		def, err := p.resolveEach(f, int(id.Pos())-base+1)
+		if err != nil || def.obj != obj {
+			// Eg, a method of an anonymous interface, or a field
+			// of an anonymous struct.
+			return true
+		}
+		tag := &Tag{
+			Name:      def.Name,
+			Container: def.Container,
+			Kind:      def.Kind,
+			Filename:  def.Filename,
+			Line:      def.Line,
+			Column:    def.Column,
+			Offset:    def.Offset,
+			Text:      lineText(src, def.Line),
+		}
+		if def.Container != "" {
+			tn, ok := obj.Pkg().Scope().Lookup(def.Container).(*types.TypeName)
+			if !ok {
+				// A field of a type declared in a function.
+				return true
+			}
+			tag.ContainerKind = typeKind(tn)
+			if tag.ContainerKind == "alias" {
+				tag.ContainerKind = "type"
+			}
+		}
+		switch obj := obj.(type) {
+		case *types.Func:
+			var buf bytes.Buffer
+			types.WriteSignature(&buf, obj.Type().(*types.Signature), types.RelativeTo(obj.Pkg()))
+			tag.Signature = buf.String()
+		case *types.TypeName:
+			tag.TypeKind = typeKind(obj)
+		}
+		tags = append(tags, tag)
+		return true
+	})
+	return tags
+}
+
+// typeKind returns "struct", "interface", "alias" or "type",
+// according to the declaration of tn.
+func typeKind(tn *types.TypeName) string {
+	if tn.IsAlias() {
+		return "alias"
+	}
+	switch tn.Type().Underlying().(type) {
+	case *types.Struct:
+		return "struct"
+	case *types.Interface:
+		return "interface"
+	}
+	return "type"
+}
+
+// lineText returns the 1-based line of src, without its line
+// terminator.
+func lineText(src []byte, line int) string {
+	start := lineOffset(src, line)
+	end := bytes.IndexByte(src[start:], '\n')
+	if end == -1 {
+		end = len(src) - start
+	}
+	return string(bytes.TrimSuffix(src[start:start+end], []byte("\r")))
+}
@@ -0,0 +1,90 @@
+package godefinfo
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	const src = `package p
+
+type I interface {
+	M(x int) error
+}
+
+type L[T any] struct {
+	next *L[T]
+	Val  T
+}
+
+func (l *L[T]) Push(v T) *L[T] { return &L[T]{l, v} }
+
+type A = L[int]
+
+var V, w = struct{ F int }{}, 1
+
+var X interface{ M() }
+
+const C = 1
+
+func F() (n int) {
+	type local struct{ G int }
+	return 0
+}
+`
+	gopath := t.TempDir()
+	withGOPATH(t, gopath)
+	t.Setenv("GO111MODULE", "off")
+	root := filepath.Join(gopath, "src")
+	writeFiles(t, root, map[string]string{
+		"p/p.go":              src,
+		"p/p_test.go":         "package p_test\n\nfunc TestX() {}\n",
+		"testdata/q/q.go":     "package q\n\nfunc Q() {}\n",
+		"p/.hidden/hidden.go": "package hidden\n\nfunc H() {}\n",
+	})
+	tags, err := NewResolver(Options{Strict: true, ImportSrc: true}).Tags(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, tag := range tags {
+		rel, _ := filepath.Rel(root, tag.Filename)
+		s := fmt.Sprintf("%s:%d:%d %s %s", filepath.ToSlash(rel), tag.Line, tag.Column, tag.Kind, tag.Name)
+		if tag.Container != "" {
+			s += " " + tag.ContainerKind + ":" + tag.Container
+		}
+		if tag.TypeKind != "" {
+			s += " " + tag.TypeKind
+		}
+		if tag.Signature != "" {
+			s += " " + tag.Signature
+		}
+		got = append(got, s)
+
+		if want := strings.Split(src, "\n")[tag.Line-1]; rel == filepath.Join("p", "p.go") && (tag.Text != want || src[tag.Offset-1:tag.Offset-1+len(tag.Name)] != tag.Name) {
+			t.Errorf("%s: got text %q at offset %d, want %q", s, tag.Text, tag.Offset, want)
+		}
+	}
+	want := []string{
+		"p/p.go:3:6 type I interface",
+		"p/p.go:4:2 method M interface:I (x int) error",
+		"p/p.go:7:6 type L struct",
+		"p/p.go:8:2 field next struct:L",
+		"p/p.go:9:2 field Val struct:L",
+		"p/p.go:12:16 method Push struct:L (v T) *L[T]",
+		"p/p.go:14:6 type A alias",
+		"p/p.go:16:5 var V",
+		"p/p.go:16:8 var w",
+		"p/p.go:18:5 var X",
+		"p/p.go:20:7 const C",
+		"p/p.go:22:6 func F () (n int)",
+		"p/p_test.go:3:6 func TestX ()",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got tags:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,21 @@
+package app
+
+/* uses GOMODCACHE=/path/to/godefinfo/testdata/modules/modcache */
+import (
+	"example.com/Upper"
+	"example.com/app/sub"
+	"example.com/dep/x"
+	"example.com/local"
+	"example.com/renamed"
+)
+
+func init() {
+	A         // example.com/app A
+	sub.S     // example.com/app/sub S
+	x.X       // example.com/dep/x X
+	upper.U   // example.com/Upper U
+	local.L   // example.com/local L
+	renamed.R // example.com/renamed R
+}
+
+func A() {}
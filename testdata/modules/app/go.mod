@@ -0,0 +1,14 @@
+module example.com/app
+
+go 1.16
+
+require (
+	example.com/Upper v1.0.0 // indirect
+	example.com/dep v1.2.3
+	example.com/local v1.0.0
+	example.com/renamed v1.0.0
+)
+
+replace example.com/local => ../local
+
+replace example.com/renamed v1.0.0 => example.com/newname v1.1.0
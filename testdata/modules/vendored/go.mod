@@ -0,0 +1,5 @@
+module example.com/vendored
+
+go 1.16
+
+require example.com/v v1.0.0
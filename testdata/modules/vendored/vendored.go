@@ -0,0 +1,7 @@
+package vendored
+
+import "example.com/v"
+
+func init() {
+	v.V // example.com/v V
+}
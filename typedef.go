@@ -0,0 +1,126 @@
+package godefinfo
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// TypeDefinitions finds the definitions of the named types that make
+// up the type of the expression at the 1-based byte offset in the
+// named file (see Resolve). Pointers, slices, arrays, maps, channels,
+// function results and aliases are followed, so the type of a
+// map[K]*V expression yields the definitions of K and V. Type
+// arguments of instantiated generic types are followed too. Basic
+// types yield builtin definitions.
+func (p *Package) TypeDefinitions(filename string, offset int) ([]*DefInfo, error) {
+	file := p.file(filename)
+	if file == nil {
+		return nil, errorf(ErrNoIdentifier, "%s is not in package %s", filename, p.pkg.Path())
+	}
+	nodes, err := p.pathAt(file, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := p.exprType(nodes)
+	if typ == nil {
+		return nil, errorf(ErrNoTypeInfo, "no type information for expression at %d", offset)
+	}
+
+	var defs []*DefInfo
+	seen := map[types.Type]bool{}
+	var visit func(t types.Type)
+	visit = func(t types.Type) {
+		t = types.Unalias(t)
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		switch t := t.(type) {
+		case *types.Named:
+			if def, ok := typeInfo(t, p.files); ok {
+				defs = append(defs, def)
+			}
+			args := t.TypeArgs()
+			for i := 0; i < args.Len(); i++ {
+				visit(args.At(i))
+			}
+		case *types.Basic:
+			if t.Info()&types.IsUntyped == 0 && t.Kind() != types.Invalid {
+				if def, ok := typeInfo(t, p.files); ok {
+					defs = append(defs, def)
+				}
+			}
+		case *types.TypeParam:
+			if def, ok := typeInfo(t, p.files); ok {
+				defs = append(defs, def)
+			}
+		case *types.Pointer:
+			visit(t.Elem())
+		case *types.Slice:
+			visit(t.Elem())
+		case *types.Array:
+			visit(t.Elem())
+		case *types.Map:
+			visit(t.Key())
+			visit(t.Elem())
+		case *types.Chan:
+			visit(t.Elem())
+		case *types.Signature:
+			visit(t.Results())
+		case *types.Tuple:
+			for i := 0; i < t.Len(); i++ {
+				visit(t.At(i).Type())
+			}
+		}
+	}
+	visit(typ)
+	if len(defs) == 0 {
+		return nil, errorf(ErrNotFound, "type %s has no named type", typ)
+	}
+	for _, def := range defs {
+		p.complete(def)
+	}
+	return defs, nil
+}
+
+// exprType returns the type of the innermost expression in path (or,
+// if it is a type expression, the type it denotes), or nil if it is
+// unknown.
+func (p *Package) exprType(path []ast.Node) types.Type {
+	var expr ast.Expr
+	for i, n := range path {
+		if e, ok := n.(ast.Expr); ok {
+			expr = e
+			// The selector x.f is the expression at f.
+			if id, ok := e.(*ast.Ident); ok && i+1 < len(path) {
+				if sel, ok := path[i+1].(*ast.SelectorExpr); ok && sel.Sel == id {
+					expr = sel
+				}
+			}
+			break
+		}
+	}
+	if expr == nil {
+		return nil
+	}
+
+	if tv, ok := p.info.Types[expr]; ok {
+		return tv.Type
+	}
+	if id, ok := expr.(*ast.Ident); ok {
+		obj := p.info.Defs[id]
+		if obj == nil {
+			obj = p.info.Uses[id]
+		}
+		if obj == nil {
+			obj = typeSwitchVar(p.info, path, id)
+		}
+		if obj != nil {
+			if _, ok := obj.(*types.PkgName); !ok {
+				return obj.Type()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package godefinfo
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTypeDefinitions(t *testing.T) {
+	const src = `package p
+
+import "net/http"
+
+type K string
+type V struct{}
+type A = *V
+type List[E any] []E
+
+func F() (*K, error) { return nil, nil }
+
+func init() {
+	var m map[K][]*V
+	_ = m
+	var ch <-chan A
+	_ = ch
+	var l List[K]
+	_ = l
+	_ = F
+	_ = http.DefaultClient
+	x := 1
+	_ = x
+	var s struct{}
+	_ = s
+}
+`
+	filename := filepath.Join(t.TempDir(), "p.go")
+	p, err := NewResolver(Options{Strict: true, ImportSrc: true}).Check(context.Background(), Query{Filename: filename, Src: []byte(src)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at   string // text that ends with the identifier
+		want []string
+	}{
+		{"_ = m", []string{"p K", "p V"}},
+		{"_ = ch", []string{"p V"}},
+		{"_ = l", []string{"p List", "p K"}},
+		{"_ = F", []string{"p K", "builtin error"}},
+		{"http.DefaultClient", []string{"net/http Client"}},
+		{"_ = x", []string{"builtin int"}},
+		{"type K", []string{"p K"}},
+		{"_ = s", nil},
+	}
+	for _, test := range tests {
+		defs, err := p.TypeDefinitions("", strings.Index(src, test.at)+len(test.at))
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("%s: got %v, want error", test.at, defs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.at, err)
+			continue
+		}
+		var got []string
+		for _, def := range defs {
+			got = append(got, def.String())
+		}
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("%s: got %q, want %q", test.at, got, test.want)
+		}
+	}
+}